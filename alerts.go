@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// alertBurst is how many failure alerts may be posted within alerts.min_interval in total,
+// so an outage that breaks every search at once doesn't flood the admins
+const alertBurst = 5
+
+// alertState is what the alerter knows about one search or sink
+type alertState struct {
+	failures    int
+	lastSuccess time.Time
+	lastAlert   time.Time
+	alerted     bool
+}
+
+// alerter tells the admins, in a Discord channel and/or by DM, when a search or a sink
+// keeps failing, and again once it works.
+type alerter struct {
+	mu       sync.Mutex
+	channel  string
+	users    []string
+	after    int
+	interval time.Duration
+	started  time.Time
+	states   map[string]*alertState
+	recent   []time.Time
+}
+
+// alerts is the application's alerter. It does nothing until it is configured with somewhere to post.
+var alerts = &alerter{
+	after:   3,
+	started: time.Now(),
+	states:  make(map[string]*alertState),
+}
+
+// configure sets where alerts are posted, after how many failures in a row,
+// and how often they may be repeated
+func (a *alerter) configure(channel string, users []string, after int, interval time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.channel = channel
+	a.users = users
+	a.after = after
+	a.interval = interval
+}
+
+// enabled reports whether there is anybody to alert. The caller must hold mu.
+func (a *alerter) enabled() bool {
+	return a.channel != "" || len(a.users) > 0
+}
+
+// state returns the state of a subject, creating it if needed. The caller must hold mu.
+func (a *alerter) state(subject string) *alertState {
+	st, ok := a.states[subject]
+	if !ok {
+		st = &alertState{lastSuccess: a.started}
+		a.states[subject] = st
+	}
+	return st
+}
+
+// failure records a failure of subject (e.g. `search "default"`), and posts an alert once
+// it has failed alerts.after times in a row. While it keeps failing, the alert is repeated
+// at most once every alerts.min_interval.
+func (a *alerter) failure(subject, url string, err error) {
+	now := time.Now()
+	a.mu.Lock()
+	st := a.state(subject)
+	st.failures++
+	if !a.enabled() || st.failures < a.after || (st.alerted && now.Sub(st.lastAlert) < a.interval) {
+		a.mu.Unlock()
+		return
+	}
+	if !a.allow(now) {
+		a.mu.Unlock()
+		alertsTotal.Inc("dropped")
+		logger.Warn("too many alerts, not posting this one", "subject", subject, "error", err)
+		return
+	}
+	st.alerted = true
+	st.lastAlert = now
+	msg := fmt.Sprintf(":rotating_light: **%s is failing** (%d times in a row, no success for %s)",
+		subject, st.failures, now.Sub(st.lastSuccess).Round(time.Second))
+	a.mu.Unlock()
+
+	if url != "" {
+		msg += "\nURL: <" + url + ">"
+	}
+	msg += "\nError: `" + redactError(err) + "`"
+	a.post(subject, msg)
+}
+
+// success records that subject works, and posts that it has recovered if it was alerted about
+func (a *alerter) success(subject string) {
+	now := time.Now()
+	a.mu.Lock()
+	st := a.state(subject)
+	alerted, failures, down := st.alerted, st.failures, now.Sub(st.lastSuccess)
+	st.failures = 0
+	st.alerted = false
+	st.lastSuccess = now
+	enabled := a.enabled()
+	a.mu.Unlock()
+
+	if alerted && enabled {
+		a.post(subject, fmt.Sprintf(":white_check_mark: **%s has recovered** after %d failures in a row (down for %s)",
+			subject, failures, down.Round(time.Second)))
+	}
+}
+
+// allow applies the overall limit of alertBurst failure alerts per interval. The caller must hold mu.
+func (a *alerter) allow(now time.Time) bool {
+	kept := a.recent[:0]
+	for _, t := range a.recent {
+		if now.Sub(t) < a.interval {
+			kept = append(kept, t)
+		}
+	}
+	a.recent = kept
+	if len(a.recent) >= alertBurst {
+		return false
+	}
+	a.recent = append(a.recent, now)
+	return true
+}
+
+// post sends an alert to the admin channel and to every admin user. Alerts that can't be
+// posted are only logged, they are never queued or retried.
+func (a *alerter) post(subject, msg string) {
+	a.mu.Lock()
+	channel, users := a.channel, a.users
+	a.mu.Unlock()
+	msg = redact(msg)
+
+	targets := make([]string, 0, len(users)+1)
+	if channel != "" {
+		targets = append(targets, channel)
+	}
+	for _, user := range users {
+		dm, err := discord.UserChannelCreate(user)
+		if err != nil {
+			alertsTotal.Inc("failed")
+			logger.Error("could not open a DM to send an alert", "subject", subject, "user", user, "error", err)
+			continue
+		}
+		targets = append(targets, dm.ID)
+	}
+	for _, target := range targets {
+		if _, err := discord.ChannelMessageSend(target, msg); err != nil {
+			alertsTotal.Inc("failed")
+			logger.Error("could not send alert", "subject", subject, "channel", target, "error", err)
+			continue
+		}
+		alertsTotal.Inc("sent")
+		logger.Info("sent alert", "subject", subject, "channel", target)
+	}
+}
+
+// searchSubject names a search in alerts
+func searchSubject(s *search) string {
+	return fmt.Sprintf("search %q", s.Name)
+}
+
+// sinkSubject names one of the places a search posts to in alerts
+func sinkSubject(searchName, sink string) string {
+	return fmt.Sprintf("%s for search %q", sink, searchName)
+}
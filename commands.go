@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// runOnce runs a single check, for running the bot from cron or a systemd timer,
+// and returns the exit code. Deliveries that fail are kept in the outbox for the next run.
+// The email digest is only sent by run, so nothing is queued for it.
+func runOnce(ctx context.Context, poll func(ctx context.Context, list []*search) error) int {
+	if digest != nil {
+		logger.Warn("the email digest is only sent by the run command, releases found now will not be mailed")
+		for _, s := range searches {
+			s.notifiers = withoutDigest(s.notifiers)
+		}
+	}
+	err := poll(ctx, searches)
+	// Searches that failed have been logged already
+	if _, ok := err.(*searchesFailed); err != nil && !ok {
+		logger.Error("could not perform check", "error", err)
+	}
+	if flushErr := box.flush(); flushErr != nil {
+		logger.Error("could not save state file", "path", cfg.Settings.StateFile, "error", flushErr)
+	}
+	discord.Close()
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// withoutDigest returns the notifiers, leaving out the email digest
+func withoutDigest(notifiers []Notifier) []Notifier {
+	result := make([]Notifier, 0, len(notifiers))
+	for _, n := range notifiers {
+		if _, ok := n.(*emailNotifier); !ok {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// searchCommand writes the products DMs Guild finds for the terms to out, as a table or as JSON.
+// Every product is listed, not only today's new ones. Nothing is posted, queued or saved,
+// so rows that can't be parsed are only logged, not quarantined.
+func searchCommand(ctx context.Context, args Args, out io.Writer) int {
+	if len(args.Rest) == 0 {
+		logger.Error("search needs the terms to search for, e.g. search \"fantasy grounds\"")
+		return 2
+	}
+	if args.Format != "table" && args.Format != "json" {
+		logger.Error("unknown output format, expected table or json", "format", args.Format)
+		return 2
+	}
+	// Keep the log out of the results
+	logger.SetOutput(os.Stderr)
+
+	s := &search{}
+	s.Name = "search"
+	s.Keywords = url.QueryEscape(strings.Join(args.Rest, " "))
+	s.TitleFilter = args.TitleFilter
+	if args.TitleRegex != "" {
+		re, err := regexp.Compile(args.TitleRegex)
+		if err != nil {
+			logger.Error("invalid title regex", "title_regex", args.TitleRegex, "error", err)
+			return 2
+		}
+		s.TitleRegex = args.TitleRegex
+		s.titleRegex = re
+	}
+
+	page, err := searchPage(ctx, s)
+	if err != nil {
+		logger.Error("could not perform DMs Guild search", "url", searchURL(s), "error", err)
+		return 1
+	}
+	for _, row := range page.Malformed {
+		logger.Warn("skipped malformed row", "search", s.Name, "row", row.Index, "error", row.Err)
+	}
+	products := make([]Product, 0, len(page.Products))
+	for _, p := range page.Products {
+		if s.matchTitle(p.Title) {
+			products = append(products, newProduct(p))
+		}
+	}
+
+	if args.Format == "json" {
+		raw, err := json.MarshalIndent(products, "", "  ")
+		if err != nil {
+			logger.Error("could not encode products", "error", err)
+			return 1
+		}
+		fmt.Fprintln(out, string(raw))
+		return 0
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDATE ADDED\tPRICE\tTITLE\tLINK")
+	for _, p := range products {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.ID, p.DateAdded, p.Price, p.Title, p.Link)
+	}
+	w.Flush()
+	return 0
+}
+
+// stateCommand lists or edits the state file: state list, state forget <id> and state reset.
+// The bot should not be running at the same time, or it will overwrite the changes.
+func stateCommand(args Args) int {
+	var err error
+	box, err = loadOutbox(cfg.Settings.StateFile, cfg.Settings.MaxAttempts)
+	if err != nil {
+		logger.Error("could not read state file", "path", cfg.Settings.StateFile, "error", err)
+		return 2
+	}
+
+	action := "list"
+	if len(args.Rest) > 0 {
+		action = args.Rest[0]
+	}
+	switch action {
+	case "list":
+		box.printState()
+	case "forget":
+		if len(args.Rest) != 2 {
+			logger.Error("state forget needs the ID or title of the product to forget")
+			return 2
+		}
+		removed, err := box.forget(args.Rest[1])
+		if err != nil {
+			logger.Error("could not save state file", "path", cfg.Settings.StateFile, "error", err)
+			return 1
+		}
+		if removed == 0 {
+			fmt.Println("Nothing is known about " + args.Rest[1] + ".")
+			return 1
+		}
+		fmt.Printf("Forgot %d entries for %s.\n", removed, args.Rest[1])
+	case "reset":
+		if err = box.reset(); err != nil {
+			logger.Error("could not save state file", "path", cfg.Settings.StateFile, "error", err)
+			return 1
+		}
+		fmt.Println("State reset.")
+	default:
+		logger.Error("unknown state command, expected list, forget or reset", "command", action)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spkane/discord_bot_dmsguild_search/dmsguild"
+)
+
+// withFixtureClient points the DMs Guild client at a server that answers every search
+// with the first results page in dmsguild/testdata, for the duration of a test
+func withFixtureClient(t *testing.T) {
+	t.Helper()
+	body, err := ioutil.ReadFile(filepath.Join("dmsguild", "testdata", "search_page1.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/browse.php" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(body)
+	}))
+	saved := client
+	client = dmsguild.NewClient(dmsguild.WithBaseURL(srv.URL))
+	t.Cleanup(func() {
+		client = saved
+		srv.Close()
+	})
+}
+
+func TestSearchCommand(t *testing.T) {
+	withFixtureClient(t)
+	savedCfg := cfg
+	t.Cleanup(func() { cfg = savedCfg })
+	dir, err := ioutil.TempDir("", "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg.Dmsguild.Affiliate = "42"
+	cfg.Settings.Quarantine = filepath.Join(dir, "quarantine")
+
+	var out bytes.Buffer
+	if code := searchCommand(context.Background(), Args{Rest: []string{"tomb", "of horrors"}, Format: "table"}, &out); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "ID") {
+		t.Fatalf("expected a header and two products, got\n%s", out.String())
+	}
+	// Every product is listed, including the ones from before today
+	if !strings.Contains(lines[1], "Tomb of Horrors") || !strings.Contains(lines[1], "?it=1&affiliate_id=42") {
+		t.Errorf("unexpected first row %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "Free Adventure") || !strings.Contains(lines[2], "2026-10-17") {
+		t.Errorf("unexpected second row %q", lines[2])
+	}
+	// The malformed rows are only logged
+	if _, err := os.Stat(cfg.Settings.Quarantine); !os.IsNotExist(err) {
+		t.Errorf("search quarantined rows: %v", err)
+	}
+
+	out.Reset()
+	if code := searchCommand(context.Background(), Args{Rest: []string{"tomb"}, Format: "json", TitleFilter: "Free"}, &out); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	var products []Product
+	if err := json.Unmarshal(out.Bytes(), &products); err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 1 || products[0].ID != "456" || products[0].Message == "" {
+		t.Errorf("unexpected products %+v", products)
+	}
+}
+
+func TestSearchCommandUsage(t *testing.T) {
+	var out bytes.Buffer
+	if code := searchCommand(context.Background(), Args{Format: "table"}, &out); code != 2 {
+		t.Errorf("without terms: exit code %d", code)
+	}
+	if code := searchCommand(context.Background(), Args{Rest: []string{"tomb"}, Format: "xml"}, &out); code != 2 {
+		t.Errorf("unknown format: exit code %d", code)
+	}
+	if code := searchCommand(context.Background(), Args{Rest: []string{"tomb"}, Format: "table", TitleRegex: "("}, &out); code != 2 {
+		t.Errorf("invalid regex: exit code %d", code)
+	}
+	if out.Len() != 0 {
+		t.Errorf("printed %q", out.String())
+	}
+}
+
+func TestAffiliateLink(t *testing.T) {
+	tests := []struct {
+		link, want string
+	}{
+		{"https://www.dmsguild.com/product/123/Tomb", "https://www.dmsguild.com/product/123/Tomb?affiliate_id=42"},
+		{"https://www.dmsguild.com/product/123/Tomb?it=1", "https://www.dmsguild.com/product/123/Tomb?it=1&affiliate_id=42"},
+		// An affiliate ID that is already there is replaced
+		{"https://www.dmsguild.com/product/123/Tomb?affiliate_id=7&it=1", "https://www.dmsguild.com/product/123/Tomb?it=1&affiliate_id=42"},
+		{"https://www.dmsguild.com/product/123/Tomb?it=1#reviews", "https://www.dmsguild.com/product/123/Tomb?it=1&affiliate_id=42#reviews"},
+	}
+	for _, tt := range tests {
+		if got := affiliateLink(tt.link, "42"); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.link, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// placeholder is the value example-config.yaml uses for the settings that must be filled in
+const placeholder = "REPLACE_THIS"
+
+// snowflakePattern matches Discord IDs
+var snowflakePattern = regexp.MustCompile(`^[0-9]{17,20}$`)
+
+// sendAtPattern matches the times of day gocron accepts
+var sendAtPattern = regexp.MustCompile(`^([01]?[0-9]|2[0-3]):[0-5][0-9](:[0-5][0-9])?$`)
+
+// Minutes is a number of minutes.
+// In YAML it can be written as a number or, like older configs did, as a string.
+type Minutes int
+
+// UnmarshalYAML reads minutes: 15 as well as minutes: "15"
+func (m *Minutes) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return m.SetValue(s)
+}
+
+// SetValue reads the minutes from an environment variable or default
+func (m *Minutes) SetValue(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not a whole number of minutes", s)
+	}
+	*m = Minutes(n)
+	return nil
+}
+
+// Duration returns the minutes as a time.Duration
+func (m Minutes) Duration() time.Duration {
+	return time.Duration(m) * time.Minute
+}
+
+// searchConfigs returns the configured searches, or the single "default" search
+// built from the top level sections when there aren't any.
+func searchConfigs(cfg Config) []SearchConfig {
+	if len(cfg.Searches) > 0 {
+		return cfg.Searches
+	}
+	def := SearchConfig{
+		Name:        "default",
+		Keywords:    cfg.Dmsguild.Keywords,
+		TitleFilter: cfg.Dmsguild.TitleFilter,
+		TitleRegex:  cfg.Dmsguild.TitleRegex,
+		Schedule:    cfg.Dmsguild.Schedule,
+		Timezone:    cfg.Dmsguild.Timezone,
+		QuietHours:  cfg.Dmsguild.QuietHours,
+	}
+	def.Discord.Channel = cfg.Discord.Channel
+	def.Slack.WebhookURL = cfg.Slack.WebhookURL
+	def.Matrix.Room = cfg.Matrix.Room
+	def.Email.Enabled = len(cfg.Email.Subscribers) > 0
+	def.Mastodon.Enabled = cfg.Mastodon.Instance != "" && cfg.Mastodon.Token != ""
+	def.Telegram.ChatID = cfg.Telegram.ChatID
+	for _, u := range cfg.Webhook.URLs {
+		def.Webhooks = append(def.Webhooks, WebhookConfig{URL: u, Secret: cfg.Webhook.Secret})
+	}
+	return []SearchConfig{def}
+}
+
+// validateConfig checks the configuration for mistakes that would otherwise only show up
+// at the first search or post. It returns every problem it finds, not just the first one.
+// For dry runs nothing is posted, so the notifiers' settings are not checked.
+func validateConfig(cfg Config, dryRun bool) []error {
+	var problems []error
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, a...))
+	}
+
+	if u, err := url.Parse(cfg.Dmsguild.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("dmsguild.base_url must be an http or https URL, not %q", cfg.Dmsguild.BaseURL)
+	}
+	if cfg.Settings.Minutes <= 0 {
+		add("settings.minutes must be more than 0, not %d", cfg.Settings.Minutes)
+	}
+	if cfg.Polling.Adaptive {
+		if cfg.Polling.MinMinutes <= 0 {
+			add("polling.min_minutes must be more than 0, not %d", cfg.Polling.MinMinutes)
+		}
+		if cfg.Polling.MaxMinutes < cfg.Polling.MinMinutes {
+			add("polling.max_minutes (%d) can't be less than polling.min_minutes (%d)", cfg.Polling.MaxMinutes, cfg.Polling.MinMinutes)
+		}
+	}
+	if cfg.Polling.Jitter < 0 {
+		add("polling.jitter can't be negative, not %s", cfg.Polling.Jitter)
+	}
+	if cfg.Polling.Workers <= 0 {
+		add("polling.workers must be more than 0, not %d", cfg.Polling.Workers)
+	}
+	if cfg.Polling.RequestsPerSecond < 0 {
+		add("polling.requests_per_second can't be negative, not %g", cfg.Polling.RequestsPerSecond)
+	}
+	if cfg.Polling.Burst <= 0 {
+		add("polling.burst must be more than 0, not %d", cfg.Polling.Burst)
+	}
+	if cfg.Settings.MaxAttempts <= 0 {
+		add("settings.max_attempts must be more than 0, not %d", cfg.Settings.MaxAttempts)
+	}
+	if cfg.Settings.ShutdownTimeout <= 0 {
+		add("settings.shutdown_timeout must be more than 0, not %s", cfg.Settings.ShutdownTimeout)
+	}
+	if cfg.Settings.CycleTimeout <= 0 {
+		add("settings.cycle_timeout must be more than 0, not %s", cfg.Settings.CycleTimeout)
+	}
+	if cfg.Settings.ReloadInterval < 0 {
+		add("settings.reload_interval can't be negative, not %s", cfg.Settings.ReloadInterval)
+	}
+	if cfg.Settings.StateFile == "" {
+		add("settings.state_file is not set")
+	}
+	if err := (&Logger{}).Configure(cfg.Settings.LogLevel, cfg.Settings.LogFormat); err != nil {
+		add("settings: %v", err)
+	}
+
+	if cfg.Feeds.Enabled {
+		if cfg.Server.Listen == "" {
+			add("feeds are enabled, but server.listen is not set")
+		}
+		if cfg.Feeds.Size <= 0 {
+			add("feeds.size must be more than 0, not %d", cfg.Feeds.Size)
+		}
+	}
+	if cfg.Metrics.Enabled && cfg.Server.Listen == "" {
+		add("metrics are enabled, but server.listen is not set")
+	}
+	if cfg.Health.Enabled {
+		if cfg.Server.Listen == "" {
+			add("health checks are enabled, but server.listen is not set")
+		}
+		if cfg.Health.StaleFactor <= 0 {
+			add("health.stale_factor must be more than 0, not %d", cfg.Health.StaleFactor)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, sc := range searchConfigs(cfg) {
+		name := sc.Name
+		if name == "" {
+			name = "search-" + strconv.Itoa(i+1)
+		}
+		if names[name] {
+			add("duplicate search name %q", name)
+		}
+		names[name] = true
+		if sc.Keywords == "" && cfg.Dmsguild.Keywords == "" {
+			add("search %q has no keywords", name)
+		}
+		if sc.TitleRegex != "" {
+			if _, err := regexp.Compile(sc.TitleRegex); err != nil {
+				add("search %q has an invalid title_regex: %v", name, err)
+			}
+		}
+		if _, _, _, err := parseSchedule(sc); err != nil {
+			add("search %q: %v", name, err)
+		}
+	}
+
+	if !dryRun {
+		problems = append(problems, validateNotifiers(cfg)...)
+	}
+	return problems
+}
+
+// validateNotifiers checks the settings of the places the searches post to
+func validateNotifiers(cfg Config) []error {
+	var problems []error
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, a...))
+	}
+	notPlaceholder := func(name, value string) {
+		if strings.Contains(value, placeholder) {
+			add("%s is still set to %s", name, placeholder)
+		}
+	}
+
+	notPlaceholder("discord.token", cfg.Discord.Token)
+	notPlaceholder("slack.webhook_url", cfg.Slack.WebhookURL)
+	notPlaceholder("matrix.token", cfg.Matrix.Token)
+	notPlaceholder("email.password", cfg.Email.Password)
+	notPlaceholder("webhook.secret", cfg.Webhook.Secret)
+	notPlaceholder("mastodon.token", cfg.Mastodon.Token)
+	notPlaceholder("telegram.token", cfg.Telegram.Token)
+
+	if cfg.Email.Host != "" {
+		if cfg.Email.Port <= 0 || cfg.Email.Port > 65535 {
+			add("email.port must be between 1 and 65535, not %d", cfg.Email.Port)
+		}
+		if !sendAtPattern.MatchString(cfg.Email.SendAt) {
+			add("email.send_at must be a time of day like 18:00, not %q", cfg.Email.SendAt)
+		}
+		if cfg.Email.From != "" {
+			if _, err := mail.ParseAddress(cfg.Email.From); err != nil {
+				add("email.from %q is not an email address: %v", cfg.Email.From, err)
+			}
+		}
+		for _, subscriber := range cfg.Email.Subscribers {
+			if _, err := mail.ParseAddress(subscriber); err != nil {
+				add("email subscriber %q is not an email address: %v", subscriber, err)
+			}
+		}
+	}
+	if cfg.Mastodon.Instance != "" {
+		switch cfg.Mastodon.Visibility {
+		case "public", "unlisted", "private", "direct":
+		default:
+			add("mastodon.visibility must be public, unlisted, private or direct, not %q", cfg.Mastodon.Visibility)
+		}
+		if n := utf8.RuneCountInString(mastodonTags(cfg.Mastodon.Hashtags)); n > mastodonHashtagLimit {
+			add("mastodon.hashtags are %d characters long, they can't be more than %d", n, mastodonHashtagLimit)
+		}
+	}
+
+	if cfg.Alerts.After <= 0 {
+		add("alerts.after must be more than 0, not %d", cfg.Alerts.After)
+	}
+	if cfg.Alerts.MinInterval < 0 {
+		add("alerts.min_interval can't be negative, not %s", cfg.Alerts.MinInterval)
+	}
+	if cfg.Alerts.Channel != "" && !snowflakePattern.MatchString(cfg.Alerts.Channel) {
+		add("alerts.channel %q is not a channel ID", cfg.Alerts.Channel)
+	}
+	for _, user := range cfg.Alerts.Users {
+		if !snowflakePattern.MatchString(user) {
+			add("alerts.users: %q is not a user ID", user)
+		}
+	}
+
+	usesDiscord := cfg.Alerts.Channel != "" || len(cfg.Alerts.Users) > 0
+	for i, sc := range searchConfigs(cfg) {
+		name := sc.Name
+		if name == "" {
+			name = "search-" + strconv.Itoa(i+1)
+		}
+		if sc.Discord.Channel != "" {
+			usesDiscord = true
+			if !snowflakePattern.MatchString(sc.Discord.Channel) {
+				add("search %q: Discord channel %q is not a channel ID", name, sc.Discord.Channel)
+			}
+		}
+		notPlaceholder("search "+strconv.Quote(name)+" slack webhook_url", sc.Slack.WebhookURL)
+		notPlaceholder("search "+strconv.Quote(name)+" matrix room_id", sc.Matrix.Room)
+		notPlaceholder("search "+strconv.Quote(name)+" telegram chat_id", sc.Telegram.ChatID)
+		for _, wh := range sc.Webhooks {
+			notPlaceholder("search "+strconv.Quote(name)+" webhook secret", wh.Secret)
+		}
+	}
+	if usesDiscord && cfg.Discord.Token == "" {
+		add("discord.token is not set, but a search or the alerts post to Discord")
+	}
+	return problems
+}
+
+// checkConfig prints the -check-config report and returns the exit code:
+// 0 when everything checks out, 1 when it doesn't.
+func checkConfig(args Args, problems []error) int {
+	fmt.Println("Checking " + args.ConfigPath)
+	if len(problems) == 0 {
+		var err error
+		discord, err = discordgo.New("Bot " + cfg.Discord.Token)
+		if err == nil {
+			err = setup(cfg)
+		}
+		if err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Println("FAIL " + redactError(p))
+		}
+		fmt.Printf("%d problem(s) found\n", len(problems))
+		return 1
+	}
+
+	fmt.Printf("ok   configuration: checking every %d minute(s)\n", cfg.Settings.Minutes)
+	for _, s := range searches {
+		fmt.Printf("ok   search %q: keywords %q, posts to %s\n", s.Name, s.Keywords, sinkNames(s))
+	}
+
+	if args.CheckDiscord {
+		if cfg.Discord.Token == "" {
+			fmt.Println("skip discord: discord.token is not set")
+			return 0
+		}
+		report, ok := checkDiscord(discord, searches, cfg.Alerts.Channel)
+		for _, line := range report {
+			fmt.Println(redact(line))
+		}
+		if !ok {
+			return 1
+		}
+	}
+	return 0
+}
+
+// checkDiscord logs in with the configured token and makes sure the bot can see
+// and post to the channel of every search that posts to Discord.
+// The alerts channel, if there is one, is checked as well.
+// It returns one line per check for the -check-config report.
+func checkDiscord(session *discordgo.Session, list []*search, alertsChannel string) ([]string, bool) {
+	report := make([]string, 0)
+	me, err := session.User("@me")
+	if err != nil {
+		return append(report, "FAIL discord token: "+err.Error()), false
+	}
+	report = append(report, "ok   discord token: logged in as "+me.Username+"#"+me.Discriminator)
+
+	ok := true
+	checked := make(map[string]bool)
+	for _, s := range list {
+		for _, n := range s.notifiers {
+			dn, isDiscord := n.(*discordNotifier)
+			if !isDiscord || checked[dn.channel] {
+				continue
+			}
+			checked[dn.channel] = true
+			line, err := checkChannel(session, me.ID, dn.channel)
+			if err != nil {
+				ok = false
+				report = append(report, "FAIL discord channel "+dn.channel+": "+err.Error())
+				continue
+			}
+			report = append(report, "ok   discord channel "+dn.channel+": "+line)
+		}
+	}
+	if alertsChannel != "" {
+		line, err := checkChannel(session, me.ID, alertsChannel)
+		if err != nil {
+			ok = false
+			report = append(report, "FAIL alerts channel "+alertsChannel+": "+err.Error())
+		} else {
+			report = append(report, "ok   alerts channel "+alertsChannel+": "+line)
+		}
+	}
+	return report, ok
+}
+
+// checkChannel makes sure the bot can see and post to a channel
+func checkChannel(session *discordgo.Session, userID, channelID string) (string, error) {
+	channel, err := session.Channel(channelID)
+	if err != nil {
+		return "", err
+	}
+	perms, err := session.UserChannelPermissions(userID, channelID)
+	if err != nil {
+		return "", err
+	}
+	if perms&discordgo.PermissionViewChannel == 0 {
+		return "", fmt.Errorf("the bot can not see #%s", channel.Name)
+	}
+	if perms&discordgo.PermissionSendMessages == 0 {
+		return "", fmt.Errorf("the bot can not send messages to #%s", channel.Name)
+	}
+	return "#" + channel.Name, nil
+}
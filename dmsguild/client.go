@@ -0,0 +1,180 @@
+// Package dmsguild searches DMs Guild (https://www.dmsguild.com) for products.
+//
+// DMs Guild has no API, so the client reads the same HTML pages a browser does:
+//
+//	client := dmsguild.NewClient()
+//	products, err := client.Search(ctx, dmsguild.Query{Keywords: "fantasy grounds"})
+//
+// Use Pages or Products to go through every page of the results.
+package dmsguild
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is where DMs Guild lives
+const DefaultBaseURL = "https://www.dmsguild.com"
+
+// SortNewest sorts the search results by the date they were added, newest first
+const SortNewest = "4a"
+
+// RateLimiter is waited on before every request.
+// *rate.Limiter from golang.org/x/time/rate implements it.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// Client searches DMs Guild. It is safe to use from several goroutines.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	limiter    RateLimiter
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithBaseURL sets where DMs Guild is, e.g. for tests. The default is DefaultBaseURL.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for the requests.
+// The default is a client with a 30 second timeout.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRateLimiter makes the client wait on limiter before every request
+func WithRateLimiter(limiter RateLimiter) Option {
+	return func(c *Client) {
+		c.limiter = limiter
+	}
+}
+
+// NewClient returns a client for DMs Guild
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		baseURL:    DefaultBaseURL,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// BaseURL returns where the client finds DMs Guild
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Query is a DMs Guild search
+type Query struct {
+	// Keywords are the search terms, as typed into the search box
+	Keywords string
+	// Page is the page of the results, starting at 1. 0 is the first page.
+	Page int
+	// Sort is DMs Guild's sort order. The default is SortNewest.
+	Sort string
+}
+
+// URL returns the search results page of the query
+func (c *Client) URL(q Query) string {
+	page := q.Page
+	if page < 1 {
+		page = 1
+	}
+	sort := q.Sort
+	if sort == "" {
+		sort = SortNewest
+	}
+	return c.baseURL + "/browse.php?keywords=" + url.QueryEscape(q.Keywords) + "&page=" + strconv.Itoa(page) + "&sort=" + sort
+}
+
+// Search returns the products on one page of the search results.
+// Rows that can't be parsed are left out, use SearchPage to see them.
+func (c *Client) Search(ctx context.Context, q Query) ([]Product, error) {
+	page, err := c.SearchPage(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return page.Products, nil
+}
+
+// SearchPage returns one page of the search results
+func (c *Client) SearchPage(ctx context.Context, q Query) (*Page, error) {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	pageURL := c.URL(q)
+	body, err := c.get(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	page, err := c.parsePage(ctx, body, q.Page)
+	if err != nil {
+		return nil, err
+	}
+	page.URL = pageURL
+	return page, nil
+}
+
+// Product returns a single product by its DMs Guild ID.
+// The product page doesn't show when the product was added, so DateAdded is empty.
+func (c *Client) Product(ctx context.Context, id string) (*Product, error) {
+	if id == "" || strings.Trim(id, "0123456789") != "" {
+		return nil, fmt.Errorf("%q is not a DMs Guild product ID", id)
+	}
+	link := c.baseURL + "/product/" + id
+	body, err := c.get(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	p, err := c.parseProductPage(body)
+	if err != nil {
+		return nil, fmt.Errorf("product %s: %v", id, err)
+	}
+	p.ID = id
+	if p.Link == "" {
+		p.Link = link
+	}
+	return p, nil
+}
+
+// get fetches a page, after waiting for the rate limiter
+func (c *Client) get(ctx context.Context, pageURL string) (string, error) {
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return "", err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", pageURL, resp.Status)
+	}
+	return string(body), nil
+}
@@ -0,0 +1,96 @@
+package dmsguild
+
+import "context"
+
+// PageIterator goes through the pages of search results:
+//
+//	it := client.Pages(query)
+//	for it.Next(ctx) {
+//		page := it.Page()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type PageIterator struct {
+	client *Client
+	query  Query
+	page   *Page
+	err    error
+	done   bool
+}
+
+// Pages returns an iterator over the pages of the search results, starting at q.Page
+func (c *Client) Pages(q Query) *PageIterator {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	return &PageIterator{client: c, query: q}
+}
+
+// Next fetches the next page. It returns false when there are no more pages,
+// or when fetching one failed.
+func (it *PageIterator) Next(ctx context.Context) bool {
+	if it.done {
+		return false
+	}
+	if it.page != nil {
+		it.query.Page++
+	}
+	page, err := it.client.SearchPage(ctx, it.query)
+	if err != nil {
+		it.err = err
+		it.done = true
+		it.page = nil
+		return false
+	}
+	it.page = page
+	it.done = !page.HasNext
+	return true
+}
+
+// Page returns the page fetched by the last call to Next
+func (it *PageIterator) Page() *Page {
+	return it.page
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *PageIterator) Err() error {
+	return it.err
+}
+
+// ProductIterator goes through the products of every page of search results,
+// fetching the pages as they are needed. It is used like PageIterator.
+type ProductIterator struct {
+	pages    *PageIterator
+	products []Product
+	product  Product
+}
+
+// Products returns an iterator over the products of the search results, starting at q.Page
+func (c *Client) Products(q Query) *ProductIterator {
+	return &ProductIterator{pages: c.Pages(q)}
+}
+
+// Next moves on to the next product. It returns false when there are no more products,
+// or when fetching a page failed.
+func (it *ProductIterator) Next(ctx context.Context) bool {
+	for len(it.products) == 0 {
+		if !it.pages.Next(ctx) {
+			return false
+		}
+		it.products = it.pages.Page().Products
+	}
+	it.product, it.products = it.products[0], it.products[1:]
+	return true
+}
+
+// Product returns the product the last call to Next moved to
+func (it *ProductIterator) Product() Product {
+	return it.product
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *ProductIterator) Err() error {
+	return it.pages.Err()
+}
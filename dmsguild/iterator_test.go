@@ -0,0 +1,106 @@
+package dmsguild
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPages(t *testing.T) {
+	srv, requests := fixtureServer(t)
+	c := NewClient(WithBaseURL(srv.URL))
+
+	var numbers []int
+	it := c.Pages(Query{Keywords: "tomb"})
+	for it.Next(context.Background()) {
+		numbers = append(numbers, it.Page().Number)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(numbers) != 2 || numbers[0] != 1 || numbers[1] != 2 {
+		t.Errorf("got pages %v, want 1 and 2", numbers)
+	}
+	// Page 2 has no next page, so page 3 is never asked for
+	if len(*requests) != 2 {
+		t.Errorf("expected 2 requests, got %v", *requests)
+	}
+	if it.Next(context.Background()) {
+		t.Error("Next returned true after the last page")
+	}
+}
+
+func TestProducts(t *testing.T) {
+	srv, _ := fixtureServer(t)
+	c := NewClient(WithBaseURL(srv.URL))
+
+	var titles []string
+	it := c.Products(Query{Keywords: "tomb"})
+	for it.Next(context.Background()) {
+		titles = append(titles, it.Product().Title)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(titles, ", "); got != "Tomb of Horrors, Free Adventure, Last Page" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestPagesStartingPage(t *testing.T) {
+	srv, requests := fixtureServer(t)
+	c := NewClient(WithBaseURL(srv.URL))
+
+	it := c.Products(Query{Keywords: "tomb", Page: 2})
+	if !it.Next(context.Background()) || it.Product().Title != "Last Page" {
+		t.Fatalf("expected the product on page 2, got %+v, %v", it.Product(), it.Err())
+	}
+	if it.Next(context.Background()) {
+		t.Errorf("expected only one product, got %+v", it.Product())
+	}
+	if len(*requests) != 1 || !strings.Contains((*requests)[0], "page=2") {
+		t.Errorf("requested %v", *requests)
+	}
+}
+
+func TestPagesStopOnError(t *testing.T) {
+	srv, requests := fixtureServer(t)
+	// Only page 1 of the maintenance search exists, and it has no product listing
+	c := NewClient(WithBaseURL(srv.URL + "/maintenance"))
+
+	it := c.Pages(Query{Keywords: "tomb"})
+	if it.Next(context.Background()) {
+		t.Fatal("Next returned true for a page that failed")
+	}
+	if it.Err() == nil || it.Page() != nil {
+		t.Errorf("expected an error and no page, got %v and %+v", it.Err(), it.Page())
+	}
+	if it.Next(context.Background()) || len(*requests) != 1 {
+		t.Errorf("the iterator carried on after an error: %v", *requests)
+	}
+
+	products := c.Products(Query{Keywords: "tomb"})
+	if products.Next(context.Background()) || products.Err() == nil {
+		t.Error("expected the product iterator to stop with the error")
+	}
+}
+
+func TestPagesStopWhenContextIsDone(t *testing.T) {
+	srv, _ := fixtureServer(t)
+	c := NewClient(WithBaseURL(srv.URL))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := c.Products(Query{Keywords: "tomb"})
+	if !it.Next(ctx) {
+		t.Fatal(it.Err())
+	}
+	cancel()
+	// The rest of page 1 is already fetched, page 2 isn't
+	var titles []string
+	for it.Next(ctx) {
+		titles = append(titles, it.Product().Title)
+	}
+	if len(titles) != 1 || titles[0] != "Free Adventure" || it.Err() == nil {
+		t.Errorf("got %v and %v, want the rest of page 1 and the context's error", titles, it.Err())
+	}
+}
@@ -0,0 +1,276 @@
+package dmsguild
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/anaskhan96/soup"
+	"golang.org/x/net/html"
+)
+
+// Product is a single DMs Guild product
+type Product struct {
+	// ID is the DMs Guild product ID, if it could be found in the link
+	ID    string `json:"id,omitempty"`
+	Title string `json:"title"`
+	// DateAdded is the day the product was added, as YYYY-MM-DD
+	DateAdded   string `json:"date_added,omitempty"`
+	Description string `json:"description"`
+	// Price is the raw price text from DMs Guild (e.g. "$4.99 $2.99" when on sale)
+	Price string `json:"price"`
+	Link  string `json:"link"`
+	Image string `json:"image,omitempty"`
+}
+
+// Page is one page of search results
+type Page struct {
+	// Number is the page number, starting at 1
+	Number int
+	// URL is where the page was fetched from
+	URL      string
+	Products []Product
+	// Malformed are the rows that look like products but could not be parsed
+	Malformed []RowError
+	// Rows is how many rows the results table had, including the ones that are not products
+	Rows int
+	// HasNext reports whether there is a page after this one
+	HasNext bool
+}
+
+// RowError is a row of the search results that could not be parsed
+type RowError struct {
+	// Index is the position of the row in the results table
+	Index int
+	// HTML is the row's markup, to help with fixing the parser
+	HTML string
+	Err  error
+}
+
+// Error describes the row and what is wrong with it
+func (e RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Index, e.Err)
+}
+
+// titleDate separates the title from the date it was added
+var titleDate = regexp.MustCompile(` *Date Added: .*$*`)
+
+// productIDPattern finds the product ID in a product link
+var productIDPattern = regexp.MustCompile(`/product/(\d+)`)
+
+// pageParam finds the page number in a link to a page of search results
+var pageParam = regexp.MustCompile(`[?&]page=(\d+)(&|$)`)
+
+// ProductID returns the DMs Guild product ID from a product link, or an empty string
+func ProductID(link string) string {
+	m := productIDPattern.FindStringSubmatch(link)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// parsePage reads the products from a page of search results, unless ctx is done first
+func (c *Client) parsePage(ctx context.Context, body string, number int) (*Page, error) {
+	doc := soup.HTMLParse(body)
+	if doc.Error != nil {
+		return nil, doc.Error
+	}
+	table := doc.Find("table", "class", "productListing")
+	if table.Error != nil {
+		// Usually a maintenance or error page instead of the search results
+		return nil, fmt.Errorf("could not find the product listing in the DMs Guild search results: %v", table.Error)
+	}
+
+	rows := table.FindAll("tr")
+	page := &Page{Number: number, Rows: len(rows)}
+	for i, row := range rows {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p, ok, err := c.parseRow(row)
+		if err != nil {
+			page.Malformed = append(page.Malformed, RowError{Index: i, HTML: render(row), Err: err})
+			continue
+		}
+		if ok {
+			page.Products = append(page.Products, p)
+		}
+	}
+
+	next := strconv.Itoa(number + 1)
+	for _, a := range doc.FindAll("a") {
+		href := a.Attrs()["href"]
+		if m := pageParam.FindStringSubmatch(href); m != nil && m[1] == next && strings.Contains(href, "browse.php") {
+			page.HasNext = true
+			break
+		}
+	}
+	return page, nil
+}
+
+// parseRow reads a single product from a row of the search results.
+// It reports false for rows that are not products, like headers.
+// Rows that could not be parsed return an error (rather than a panic).
+func (c *Client) parseRow(row soup.Root) (p Product, ok bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+			err = fmt.Errorf("panic while parsing row: %v", r)
+		}
+	}()
+
+	description := make([]string, 0)
+	titleLine := true
+	// DMs Guild's HTML is inconsistent at best, so go through the text line by line
+	// and pull out what we want.
+	for _, s := range strings.Split(row.FullText(), "\n") {
+		line := strings.TrimSpace(s)
+		if line == "" {
+			continue
+		}
+		if titleLine {
+			titleLine = false
+			var firstLine string
+			p.Title, p.DateAdded, firstLine, err = parseTitleLine(line)
+			if err != nil {
+				return p, false, err
+			}
+			if p.DateAdded == "" {
+				return p, false, nil
+			}
+			if firstLine != "" {
+				description = append(description, firstLine)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "$") || line == "FREE" || line == "Pay What You Want" {
+			p.Price = line
+			continue
+		}
+		if line != "Dungeon Masters Guild" {
+			description = append(description, cleanLine(line))
+		}
+	}
+	if titleLine {
+		return p, false, nil
+	}
+	p.Description = strings.TrimSpace(strings.Join(description, "\n"))
+
+	links := row.FindAll("a")
+	if len(links) == 0 {
+		return p, false, fmt.Errorf("could not find the product link for %q", p.Title)
+	}
+	p.Link = c.resolve(links[0].Attrs()["href"])
+	if strings.Contains(p.Link, "browse.php") {
+		return p, false, nil
+	}
+	p.ID = ProductID(p.Link)
+
+	if img := row.Find("img"); img.Error == nil {
+		p.Image = c.resolve(img.Attrs()["src"])
+	}
+	return p, true, nil
+}
+
+// parseTitleLine splits the first line of a row into the title, the date it was added,
+// and the start of the description that follows the date.
+// The date is empty for rows that are not products.
+func parseTitleLine(line string) (title, date, rest string, err error) {
+	title = titleDate.Split(line, -1)[0]
+	fields := strings.Fields(line)
+	for i, v := range fields {
+		if v != "Added:" {
+			continue
+		}
+		if i+1 >= len(fields) || len(fields[i+1]) < 10 {
+			return "", "", "", fmt.Errorf("could not find the date after \"Date Added:\" in %q", line)
+		}
+		date = fields[i+1][0:10]
+		rest = strings.Join(append([]string{fields[i+1][10:]}, fields[i+2:]...), " ")
+		return title, date, cleanLine(rest), nil
+	}
+	return title, "", "", nil
+}
+
+// cleanLine tidies up the spacing of a line of the description, and removes
+// the [click here for more...] text that DMs Guild adds to shortened descriptions
+func cleanLine(s string) string {
+	words := make([]string, 0)
+	for _, v := range strings.Fields(s) {
+		if v == "[click" {
+			break
+		}
+		words = append(words, v)
+	}
+	return strings.Join(words, " ")
+}
+
+// parseProductPage reads a product from its page, using the page's metadata
+func (c *Client) parseProductPage(body string) (*Product, error) {
+	doc := soup.HTMLParse(body)
+	if doc.Error != nil {
+		return nil, doc.Error
+	}
+	meta := make(map[string]string)
+	for _, m := range doc.FindAll("meta") {
+		attrs := m.Attrs()
+		for _, key := range []string{"property", "name", "itemprop"} {
+			if attrs[key] != "" && meta[attrs[key]] == "" {
+				meta[attrs[key]] = strings.TrimSpace(attrs["content"])
+			}
+		}
+	}
+
+	p := &Product{
+		Title:       meta["og:title"],
+		Description: meta["og:description"],
+		Link:        meta["og:url"],
+	}
+	if p.Title == "" {
+		if t := doc.Find("title"); t.Error == nil {
+			p.Title = strings.TrimSpace(t.FullText())
+		}
+	}
+	if p.Title == "" {
+		return nil, fmt.Errorf("could not find the product title")
+	}
+	if meta["og:image"] != "" {
+		p.Image = c.resolve(meta["og:image"])
+	}
+	if price, err := strconv.ParseFloat(meta["price"], 64); err == nil {
+		if price == 0 {
+			p.Price = "FREE"
+		} else {
+			p.Price = "$" + strconv.FormatFloat(price, 'f', 2, 64)
+		}
+	}
+	return p, nil
+}
+
+// resolve turns a link on a DMs Guild page into an absolute URL
+func (c *Client) resolve(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") {
+		return href
+	}
+	if strings.HasPrefix(href, "//") {
+		return "https:" + href
+	}
+	return c.baseURL + "/" + strings.TrimPrefix(href, "/")
+}
+
+// render returns the markup of a row
+func render(row soup.Root) string {
+	if row.Pointer == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := html.Render(&buf, row.Pointer); err != nil {
+		return ""
+	}
+	return buf.String()
+}
@@ -0,0 +1,203 @@
+package dmsguild
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fixtureServer serves the search results pages and the product page in testdata,
+// and records the URL of every request
+func fixtureServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.String())
+		var file string
+		switch {
+		case r.URL.Path == "/browse.php":
+			file = "search_page" + r.URL.Query().Get("page") + ".html"
+		case r.URL.Path == "/maintenance/browse.php":
+			file = "maintenance.html"
+		case strings.HasPrefix(r.URL.Path, "/product/123"):
+			file = "product.html"
+		}
+		body, err := ioutil.ReadFile(filepath.Join("testdata", file))
+		if file == "" || err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestSearchPage(t *testing.T) {
+	srv, requests := fixtureServer(t)
+	c := NewClient(WithBaseURL(srv.URL + "/"))
+
+	page, err := c.SearchPage(context.Background(), Query{Keywords: "tomb of horrors"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/browse.php?keywords=tomb+of+horrors&page=1&sort=4a"; len(*requests) != 1 || (*requests)[0] != want {
+		t.Errorf("requested %v, want %s", *requests, want)
+	}
+	if page.URL != srv.URL+"/browse.php?keywords=tomb+of+horrors&page=1&sort=4a" || page.Number != 1 {
+		t.Errorf("page %d at %s", page.Number, page.URL)
+	}
+	if page.Rows != 6 || !page.HasNext {
+		t.Errorf("got %d rows and HasNext %v, want 6 rows and a next page", page.Rows, page.HasNext)
+	}
+
+	want := []Product{
+		{
+			ID:          "123",
+			Title:       "Tomb of Horrors",
+			DateAdded:   "2026-10-18",
+			Description: "A deadly dungeon\nfor characters of levels 10 to 14.\nNow with maps.",
+			Price:       "$4.99 $2.99",
+			Link:        srv.URL + "/product/123/Tomb-of-Horrors?it=1",
+			Image:       "https://d1vzi28wh99zvq.cloudfront.net/images/123-thumb140.jpg",
+		},
+		{
+			ID:        "456",
+			Title:     "Free Adventure",
+			DateAdded: "2026-10-17",
+			Price:     "FREE",
+			Link:      "https://www.dmsguild.com/product/456/Free-Adventure",
+		},
+	}
+	if !reflect.DeepEqual(page.Products, want) {
+		t.Errorf("got products\n%+v\nwant\n%+v", page.Products, want)
+	}
+
+	// The header and the link back to the search are not products, but not malformed either
+	if len(page.Malformed) != 2 {
+		t.Fatalf("expected 2 malformed rows, got %v", page.Malformed)
+	}
+	for i, want := range []struct {
+		index int
+		err   string
+		html  string
+	}{
+		{3, `could not find the date after "Date Added:"`, "Broken Date"},
+		{4, `could not find the product link for "No Link"`, "<b>No Link</b>"},
+	} {
+		got := page.Malformed[i]
+		if got.Index != want.index || !strings.Contains(got.Error(), want.err) || !strings.Contains(got.HTML, want.html) {
+			t.Errorf("malformed row %d: got index %d, %q in %s", i, got.Index, got.Error(), got.HTML)
+		}
+	}
+
+	products, err := c.Search(context.Background(), Query{Keywords: "tomb of horrors"})
+	if err != nil || !reflect.DeepEqual(products, want) {
+		t.Errorf("Search returned %+v, %v", products, err)
+	}
+}
+
+func TestSearchPageLastPage(t *testing.T) {
+	srv, _ := fixtureServer(t)
+	c := NewClient(WithBaseURL(srv.URL))
+
+	// Page 2 links to pages 1 and 12, but not to page 3
+	page, err := c.SearchPage(context.Background(), Query{Keywords: "tomb", Page: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.HasNext || page.Number != 2 || len(page.Products) != 1 || page.Products[0].Title != "Last Page" {
+		t.Errorf("unexpected page: %+v", page)
+	}
+}
+
+func TestSearchPageErrors(t *testing.T) {
+	srv, _ := fixtureServer(t)
+
+	// A maintenance page instead of the results
+	c := NewClient(WithBaseURL(srv.URL + "/maintenance"))
+	if _, err := c.SearchPage(context.Background(), Query{Keywords: "tomb"}); err == nil || !strings.Contains(err.Error(), "product listing") {
+		t.Errorf("expected the listing to be missing, got %v", err)
+	}
+
+	c = NewClient(WithBaseURL(srv.URL))
+	if _, err := c.SearchPage(context.Background(), Query{Keywords: "tomb", Page: 3}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a 404, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.SearchPage(ctx, Query{Keywords: "tomb"}); err == nil {
+		t.Error("expected an error for a cancelled context")
+	}
+}
+
+func TestParseTitleLine(t *testing.T) {
+	tests := []struct {
+		line              string
+		title, date, rest string
+		err               bool
+	}{
+		{"Tomb of Horrors Date Added: 2026-10-18 A deadly dungeon", "Tomb of Horrors", "2026-10-18", "A deadly dungeon", false},
+		{"Tomb of Horrors Date Added: 2026-10-18", "Tomb of Horrors", "2026-10-18", "", false},
+		// The description sometimes starts right after the date
+		{"Tomb Date Added: 2026-10-18A deadly [click here for more...]", "Tomb", "2026-10-18", "A deadly", false},
+		{"Displaying 1 to 5 (of 6 products)", "Displaying 1 to 5 (of 6 products)", "", "", false},
+		{"Tomb Date Added: 2026", "", "", "", true},
+		{"Tomb Date Added:", "", "", "", true},
+	}
+	for _, tt := range tests {
+		title, date, rest, err := parseTitleLine(tt.line)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: unexpected error %v", tt.line, err)
+			continue
+		}
+		if title != tt.title || date != tt.date || rest != tt.rest {
+			t.Errorf("%q: got %q, %q, %q", tt.line, title, date, rest)
+		}
+	}
+}
+
+func TestProduct(t *testing.T) {
+	srv, _ := fixtureServer(t)
+	c := NewClient(WithBaseURL(srv.URL))
+
+	p, err := c.Product(context.Background(), "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Product{
+		ID:          "123",
+		Title:       "Tomb of Horrors",
+		Description: "A deadly dungeon.",
+		Price:       "$2.50",
+		Link:        "https://www.dmsguild.com/product/123/Tomb-of-Horrors",
+		Image:       "https://d1vzi28wh99zvq.cloudfront.net/images/123.jpg",
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+
+	for _, id := range []string{"", "12a", "../123"} {
+		if _, err := c.Product(context.Background(), id); err == nil {
+			t.Errorf("%q: expected an error", id)
+		}
+	}
+}
+
+func TestProductID(t *testing.T) {
+	for link, want := range map[string]string{
+		"https://www.dmsguild.com/product/123/Tomb-of-Horrors?it=1": "123",
+		"/product/456": "456",
+		"https://www.dmsguild.com/browse.php?page=2": "",
+	} {
+		if got := ProductID(link); got != want {
+			t.Errorf("%s: got %q, want %q", link, got, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package dmsguild
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a RateLimiter that allows rate requests per second on average,
+// and bursts of up to burst requests. Waiters are let through in the order they arrived.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full token bucket. A rate of 0 or less doesn't limit anything.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	b := &TokenBucket{last: time.Now()}
+	b.SetRate(rate, burst)
+	b.tokens = b.burst
+	return b
+}
+
+// SetRate changes the rate and burst. Requests that are already waiting keep their turn.
+func (b *TokenBucket) SetRate(rate float64, burst int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if burst < 1 {
+		burst = 1
+	}
+	b.rate = rate
+	b.burst = float64(burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// refill adds the tokens earned since the last refill. The caller must hold mu.
+func (b *TokenBucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// Wait blocks until a request may be made, or ctx is done
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+	b.refill(time.Now())
+	// Take the token now, even if that means going into debt, so later waiters queue up behind us
+	b.tokens--
+	if b.tokens >= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Give the token back, it was never used
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
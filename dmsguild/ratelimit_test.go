@@ -0,0 +1,137 @@
+package dmsguild
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// tokensLeft returns the tokens in the bucket, which is negative while requests are waiting
+func tokensLeft(b *TokenBucket) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return b.tokens
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	b := NewTokenBucket(10, 3)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("the burst took %s", elapsed)
+	}
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("the request after the burst only waited %s", elapsed)
+	}
+}
+
+func TestTokenBucketOrder(t *testing.T) {
+	b := NewTokenBucket(20, 1)
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each waiter gets the next token, 50ms after the one before it
+	done := make(chan int, 4)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			if err := b.Wait(context.Background()); err != nil {
+				t.Error(err)
+			}
+			done <- i
+		}(i)
+		time.Sleep(5 * time.Millisecond)
+	}
+	for want := 0; want < 4; want++ {
+		select {
+		case got := <-done:
+			if got != want {
+				t.Fatalf("waiter %d went before waiter %d", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("a waiter never got a token")
+		}
+	}
+}
+
+func TestTokenBucketCancel(t *testing.T) {
+	b := NewTokenBucket(1, 1)
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- b.Wait(ctx) }()
+	time.Sleep(20 * time.Millisecond)
+	if tokens := tokensLeft(b); tokens > -0.5 {
+		t.Fatalf("expected the waiter to be in debt, got %.2f tokens", tokens)
+	}
+
+	cancel()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Fatalf("got %v, want the context's error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait didn't return when the context was cancelled")
+	}
+	// The token that was never used is given back, so the next request doesn't wait for it too
+	if tokens := tokensLeft(b); tokens < -0.5 {
+		t.Errorf("the cancelled waiter kept its token, %.2f tokens left", tokens)
+	}
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	b := NewTokenBucket(0, 1)
+	for i := 0; i < 100; i++ {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.Wait(ctx); err != context.Canceled {
+		t.Errorf("got %v, want the context's error", err)
+	}
+}
+
+func TestTokenBucketSetRate(t *testing.T) {
+	b := NewTokenBucket(1, 10)
+	b.SetRate(1, 2)
+	if tokens := tokensLeft(b); tokens > 2 {
+		t.Errorf("lowering the burst left %.2f tokens", tokens)
+	}
+	b.SetRate(1, 0)
+	if b.burst != 1 {
+		t.Errorf("a burst of 0 should be 1, got %v", b.burst)
+	}
+}
+
+func TestClientWaitsForRateLimiter(t *testing.T) {
+	srv, requests := fixtureServer(t)
+	b := NewTokenBucket(1, 1)
+	c := NewClient(WithBaseURL(srv.URL), WithRateLimiter(b))
+
+	if _, err := c.Search(context.Background(), Query{Keywords: "tomb"}); err != nil {
+		t.Fatal(err)
+	}
+	// Out of tokens, so the next search gives up before making a request
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Search(ctx, Query{Keywords: "tomb"}); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want the deadline to be exceeded", err)
+	}
+	if len(*requests) != 1 {
+		t.Errorf("expected a single request, got %v", *requests)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// printNotifier writes the messages to out instead of posting them, for dry runs.
+type printNotifier struct {
+	out    io.Writer
+	search string
+}
+
+// Name returns the notifier description
+func (n *printNotifier) Name() string {
+	return "dry run"
+}
+
+// Notify writes the message exactly as it would be posted to Discord
+func (n *printNotifier) Notify(ctx context.Context, p Product) error {
+	_, err := fmt.Fprintf(n.out, "----- %s: %s -----\n%s\n\n", n.search, p.Title, p.Message)
+	return err
+}
+
+// dryRun runs every search once and writes the messages it would post to output
+// (standard output when it is "" or "-"). Nothing is posted, and the state file
+// is read but never written, so it also works without any Discord token.
+// It returns the exit code.
+func dryRun(ctx context.Context, output string) int {
+	out := io.Writer(os.Stdout)
+	if output != "" && output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			logger.Error("could not create dry run output", "path", output, "error", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	} else {
+		// Keep the log out of the messages
+		logger.SetOutput(os.Stderr)
+	}
+
+	problems := validateConfig(cfg, true)
+	if len(problems) > 0 {
+		for _, p := range problems {
+			logger.Error("invalid configuration", "error", p)
+		}
+		return 2
+	}
+
+	var err error
+	box, err = loadOutbox(cfg.Settings.StateFile, cfg.Settings.MaxAttempts)
+	if err != nil {
+		logger.Error("could not read state file", "path", cfg.Settings.StateFile, "error", err)
+		return 2
+	}
+	box.detach()
+	box.newDay(memoryDate)
+
+	list, err := baseSearches(cfg)
+	if err != nil {
+		logger.Error("could not set up searches", "error", err)
+		return 2
+	}
+	for _, s := range list {
+		s.notifiers = []Notifier{&printNotifier{out: out, search: s.Name}}
+		// Print everything now, rather than holding it until the quiet hours are over
+		s.quiet = nil
+	}
+	searchesMu.Lock()
+	searches = list
+	searchesMu.Unlock()
+
+	if err = updateMessage(ctx, list); err != nil {
+		logger.Error("dry run failed", "error", err)
+		return 1
+	}
+	if _, pending, dead := box.sizes(); pending+dead > 0 {
+		logger.Error("could not write every message", "failed", pending+dead)
+		return 1
+	}
+	return 0
+}
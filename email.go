@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emailSink is the name of the email digest in the outbox
+const emailSink = "email digest"
+
+// smtpTimeout is how long sending a digest may take when the context has no deadline
+const smtpTimeout = 5 * time.Minute
+
+// digestEntry is a product going out in an email digest.
+type digestEntry struct {
+	search  string
+	product Product
+}
+
+// emailDigest mails the matched products waiting in the outbox to every subscriber
+// at the scheduled time. The products stay in the outbox, and so in the state file,
+// until the digest with them in it has been sent.
+type emailDigest struct {
+	host        string
+	port        int
+	username    string
+	password    string
+	from        string
+	subject     string
+	subscribers []string
+}
+
+// emailNotifier queues the products of one search for the shared digest.
+type emailNotifier struct {
+	digest *emailDigest
+	search string
+}
+
+// newEmailDigest sets up the digest from the email section of the config
+func newEmailDigest(cfg Config) *emailDigest {
+	return &emailDigest{
+		host:        cfg.Email.Host,
+		port:        cfg.Email.Port,
+		username:    cfg.Email.Username,
+		password:    cfg.Email.Password,
+		from:        cfg.Email.From,
+		subject:     cfg.Email.Subject,
+		subscribers: cfg.Email.Subscribers,
+	}
+}
+
+// Name returns the notifier description
+func (n *emailNotifier) Name() string {
+	return emailSink
+}
+
+// Notify is never called by the outbox: the products wait there until the digest sends them.
+func (n *emailNotifier) Notify(ctx context.Context, p Product) error {
+	return errors.New("email digests are only sent at email.send_at")
+}
+
+// send mails the products waiting in the outbox, if there are any, and then marks them as delivered.
+// If sending fails, or ctx is done first, they are kept for the next digest.
+func (d *emailDigest) send(ctx context.Context) error {
+	items := box.digestItems()
+	if len(items) == 0 {
+		return nil
+	}
+	entries := make([]digestEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, digestEntry{search: item.Search, product: item.Product})
+	}
+
+	msg, err := d.message(entries, time.Now())
+	if err != nil {
+		logger.Error("could not build email digest", "error", err)
+		return err
+	}
+
+	var auth smtp.Auth
+	if d.username != "" {
+		auth = smtp.PlainAuth("", d.username, d.password, d.host)
+	}
+	addr := net.JoinHostPort(d.host, strconv.Itoa(d.port))
+	err = d.sendMail(ctx, addr, auth, msg)
+	if err != nil {
+		box.digestFailed(items, err)
+		logger.Error("could not send email digest", "server", addr, "error", err)
+		return err
+	}
+	box.digestSent(items)
+	logger.Info("sent email digest", "server", addr, "releases", len(entries), "subscribers", len(d.subscribers))
+	return nil
+}
+
+// envelope returns the bare addresses of the sender and the subscribers for MAIL and RCPT,
+// which don't take display names like "DMs Guild Bot <bot@example.com>" does.
+func (d *emailDigest) envelope() (string, []string, error) {
+	from, err := mail.ParseAddress(d.from)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid email.from %q: %v", d.from, err)
+	}
+	to := make([]string, 0, len(d.subscribers))
+	for _, subscriber := range d.subscribers {
+		addr, err := mail.ParseAddress(subscriber)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid email subscriber %q: %v", subscriber, err)
+		}
+		to = append(to, addr.Address)
+	}
+	return from.Address, to, nil
+}
+
+// sendMail sends msg to every subscriber, like smtp.SendMail does, but gives up when ctx is done.
+// Without a deadline on ctx, a server that stops answering is given up on after smtpTimeout.
+func (d *emailDigest) sendMail(ctx context.Context, addr string, auth smtp.Auth, msg []byte) (err error) {
+	from, subscribers, err := d.envelope()
+	if err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, smtpTimeout)
+		defer cancel()
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	// Interrupt whatever is being read or written as soon as ctx is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, d.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: d.host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("the mail server doesn't support AUTH")
+		}
+		if err = c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range subscribers {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message builds a multipart/alternative email with a plain text and an HTML version of the digest.
+// Subscribers are sent the mail as blind copies, so they don't see each other's addresses.
+func (d *emailDigest) message(entries []digestEntry, now time.Time) ([]byte, error) {
+	// Group the releases by search, keeping the order they were found in.
+	sorted := make([]digestEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].search < sorted[j].search })
+	entries = sorted
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	// The display name is only shown in the header, the envelope has the bare address
+	from, err := mail.ParseAddress(d.from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email.from %q: %v", d.from, err)
+	}
+	buf.WriteString("From: " + from.String() + "\r\n")
+	buf.WriteString("To: undisclosed-recipients:;\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", d.subject+" - "+now.Format("2006-01-02")) + "\r\n")
+	buf.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: multipart/alternative; boundary=" + mw.Boundary() + "\r\n\r\n")
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=UTF-8", digestPlain(entries)},
+		{"text/html; charset=UTF-8", digestHTML(entries)},
+	}
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err = qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err = qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// digestPlain renders the plain text version of the digest
+func digestPlain(entries []digestEntry) string {
+	text := "New DMs Guild releases\n"
+	lastSearch := ""
+	for _, e := range entries {
+		if e.search != lastSearch {
+			text = text + "\n== " + e.search + " ==\n\n"
+			lastSearch = e.search
+		}
+		p := e.product
+		text = text + p.Title + "\n"
+		text = text + "Date Added: " + p.DateAdded + "\n"
+		text = text + "Price: " + p.PriceText() + "\n"
+		if p.Description != "" {
+			text = text + p.Description + "\n"
+		}
+		text = text + p.Link + "\n\n"
+	}
+	return text
+}
+
+// digestHTML renders the HTML version of the digest
+func digestHTML(entries []digestEntry) string {
+	text := "<html><body>\n<h1>New DMs Guild releases</h1>\n"
+	lastSearch := ""
+	for _, e := range entries {
+		if e.search != lastSearch {
+			text = text + "<h2>" + html.EscapeString(e.search) + "</h2>\n"
+			lastSearch = e.search
+		}
+		p := e.product
+		text = text + "<div>\n"
+		if p.Image != "" {
+			text = text + `<img src="` + html.EscapeString(p.Image) + `" alt="" style="float:right;max-width:120px">` + "\n"
+		}
+		text = text + `<h3><a href="` + html.EscapeString(p.Link) + `">` + html.EscapeString(p.Title) + "</a></h3>\n"
+		text = text + "<p><strong>Date Added</strong>: " + html.EscapeString(p.DateAdded) + "<br>\n"
+		text = text + "<strong>Price</strong>: " + html.EscapeString(p.PriceText()) + "</p>\n"
+		if p.Description != "" {
+			text = text + "<p>" + strings.Replace(html.EscapeString(p.Description), "\n", "<br>\n", -1) + "</p>\n"
+		}
+		text = text + "</div>\n<hr style=\"clear:both\">\n"
+	}
+	return text + "</body></html>\n"
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hungSMTP accepts connections and never answers
+func hungSMTP(t *testing.T) (host string, port int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn, 10)
+	t.Cleanup(func() {
+		l.Close()
+		close(conns)
+		for conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	host, portText, _ := net.SplitHostPort(l.Addr().String())
+	port, _ = strconv.Atoi(portText)
+	return host, port
+}
+
+func TestEmailDigestGivesUpWhenContextIsDone(t *testing.T) {
+	host, port := hungSMTP(t)
+	d := &emailDigest{host: host, port: port, from: "bot@example.com", subscribers: []string{"player@example.com"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := d.sendMail(ctx, net.JoinHostPort(host, strconv.Itoa(port)), nil, []byte("Subject: test\r\n\r\nbody\r\n"))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("took %s to give up", time.Since(start))
+	}
+}
+
+// smtpSession is what a client sent to fakeSMTP
+type smtpSession struct {
+	from string
+	to   []string
+	data []byte
+}
+
+// fakeSMTP answers a single SMTP session, without STARTTLS or AUTH, and sends what it was given on the channel
+func fakeSMTP(t *testing.T) (host string, port int, sessions <-chan smtpSession) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	done := make(chan smtpSession, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c := textproto.NewConn(conn)
+		var s smtpSession
+		c.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(command, "EHLO"):
+				c.PrintfLine("250-localhost")
+				c.PrintfLine("250 8BITMIME")
+			case strings.HasPrefix(command, "MAIL FROM:"):
+				// Leave out parameters like BODY=8BITMIME
+				s.from = strings.Fields(line[len("MAIL FROM:"):])[0]
+				c.PrintfLine("250 OK")
+			case strings.HasPrefix(command, "RCPT TO:"):
+				s.to = append(s.to, line[len("RCPT TO:"):])
+				c.PrintfLine("250 OK")
+			case command == "DATA":
+				c.PrintfLine("354 Go ahead")
+				if s.data, err = c.ReadDotBytes(); err != nil {
+					return
+				}
+				c.PrintfLine("250 OK")
+			case command == "QUIT":
+				c.PrintfLine("221 Bye")
+				done <- s
+				return
+			default:
+				c.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+	host, portText, _ := net.SplitHostPort(l.Addr().String())
+	port, _ = strconv.Atoi(portText)
+	return host, port, done
+}
+
+// withOutbox replaces the outbox with one saved to a temporary state file for the duration of a test
+func withOutbox(t *testing.T, items ...*outboxItem) *outbox {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := box
+	box = &outbox{path: filepath.Join(dir, "state.json"), maxAttempts: 3, Delivered: make(map[string][]seenProduct), Pending: items}
+	t.Cleanup(func() {
+		box = saved
+		os.RemoveAll(dir)
+	})
+	return box
+}
+
+func TestEmailDigestSend(t *testing.T) {
+	host, port, sessions := fakeSMTP(t)
+	b := withOutbox(t,
+		&outboxItem{ID: "1", Search: "Forgotten Realms", Sink: emailSink, Product: Product{
+			ID: "1", Title: "Café <Adventures>", DateAdded: "2026-10-18", Price: "$4.99 $2.99",
+			Link: "https://example.com/product/1", Description: "A long line " + strings.Repeat("=", 100) + "\nand a second line",
+		}},
+		&outboxItem{ID: "2", Search: "Eberron", Sink: emailSink, Product: Product{ID: "2", Title: "Sharn", Price: "$1.00", Link: "https://example.com/product/2"}},
+		&outboxItem{ID: "3", Search: "Eberron", Sink: "Slack webhook", Product: Product{ID: "2", Title: "Sharn"}},
+	)
+	d := &emailDigest{host: host, port: port, from: "DMs Guild Bot <bot@example.com>", subject: "New releases",
+		subscribers: []string{"one@example.com", "two@example.com"}}
+
+	if err := d.send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var s smtpSession
+	select {
+	case s = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the digest never reached the server")
+	}
+
+	// The envelope only has the bare addresses, the display name is in the header
+	if s.from != "<bot@example.com>" || strings.Join(s.to, " ") != "<one@example.com> <two@example.com>" {
+		t.Errorf("sent from %s to %v", s.from, s.to)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(s.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from, err := msg.Header.AddressList("From"); err != nil || len(from) != 1 || from[0].Name != "DMs Guild Bot" || from[0].Address != "bot@example.com" {
+		t.Errorf("From header %q: %v", msg.Header.Get("From"), err)
+	}
+	// Subscribers are blind copies, their addresses are only in the envelope
+	if got := msg.Header.Get("To"); got != "undisclosed-recipients:;" {
+		t.Errorf("To header %q", got)
+	}
+	if bytes.Contains(s.data, []byte("one@example.com")) {
+		t.Error("a subscriber's address is in the message")
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || !strings.HasPrefix(subject, "New releases - ") {
+		t.Errorf("subject %q: %v", subject, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+	// Quoted-printable keeps the lines of the parts short, whatever is in the descriptions
+	for _, line := range strings.Split(string(s.data), "\n") {
+		if len(line) > 76 && !strings.HasPrefix(line, "Content-Type:") {
+			t.Errorf("line is longer than 76 characters: %q", line)
+		}
+	}
+	parts := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The multipart reader decodes quoted-printable parts
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[part.Header.Get("Content-Type")] = string(body)
+	}
+
+	plain := parts["text/plain; charset=UTF-8"]
+	for _, want := range []string{
+		"== Eberron ==\n\nSharn\n",
+		"== Forgotten Realms ==\n\nCafé <Adventures>\nDate Added: 2026-10-18\nPrice: $2.99 (normally $4.99)\n",
+		strings.Repeat("=", 100) + "\nand a second line\nhttps://example.com/product/1\n",
+	} {
+		if !strings.Contains(plain, want) {
+			t.Errorf("plain text part is missing %q:\n%s", want, plain)
+		}
+	}
+	if strings.Index(plain, "Eberron") > strings.Index(plain, "Forgotten Realms") {
+		t.Errorf("releases aren't grouped by search:\n%s", plain)
+	}
+	html := parts["text/html; charset=UTF-8"]
+	for _, want := range []string{
+		`<h3><a href="https://example.com/product/1">Café &lt;Adventures&gt;</a></h3>`,
+		"<br>\nand a second line</p>",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML part is missing %q:\n%s", want, html)
+		}
+	}
+
+	// Only the digest items are delivered
+	if len(b.Pending) != 1 || b.Pending[0].ID != "3" {
+		t.Errorf("expected only the Slack item to be left, got %+v", b.Pending)
+	}
+}
+
+func TestEmailDigestKeepsItemsWhenSendingFails(t *testing.T) {
+	host, port := hungSMTP(t)
+	b := withOutbox(t, &outboxItem{ID: "1", Search: "Eberron", Sink: emailSink, Product: Product{ID: "1", Title: "Sharn"}})
+	d := &emailDigest{host: host, port: port, from: "bot@example.com", subscribers: []string{"player@example.com"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := d.send(ctx); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// The items are still waiting in the state file for the next digest
+	saved, err := loadOutbox(b.path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Pending) != 1 || saved.Pending[0].Attempts != 1 || saved.Pending[0].LastError == "" {
+		t.Fatalf("unexpected pending items: %+v", saved.Pending)
+	}
+	if len(saved.Dead) != 0 || len(saved.Delivered) != 0 {
+		t.Fatalf("the item should only be pending: %+v", saved)
+	}
+}
+
+func TestEmailDigestInvalidAddress(t *testing.T) {
+	for _, d := range []*emailDigest{
+		{from: "DMs Guild Bot", subscribers: []string{"player@example.com"}},
+		{from: "bot@example.com", subscribers: []string{"player@example.com", "Player <player"}},
+	} {
+		// Given up on before connecting to anything
+		if err := d.sendMail(context.Background(), "127.0.0.1:0", nil, nil); err == nil || !strings.Contains(err.Error(), "invalid email") {
+			t.Errorf("from %q to %v: got %v", d.from, d.subscribers, err)
+		}
+	}
+
+	var conf Config
+	conf.Email.Host, conf.Email.Port, conf.Email.SendAt = "smtp.example.com", 587, "18:00"
+	conf.Email.From = "DMs Guild Bot <bot@example.com>"
+	conf.Email.Subscribers = []string{"player@example.com", "not an address"}
+	problems := validateNotifiers(conf)
+	found := false
+	for _, p := range problems {
+		if strings.Contains(p.Error(), "email.from") {
+			t.Errorf("a display name is allowed in email.from: %v", p)
+		}
+		found = found || strings.Contains(p.Error(), `"not an address"`)
+	}
+	if !found {
+		t.Errorf("the invalid subscriber wasn't reported: %v", problems)
+	}
+}
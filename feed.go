@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"html"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// feedItem is a matched product and the time we found it.
+type feedItem struct {
+	product Product
+	found   time.Time
+}
+
+// feedStore keeps the most recent products matched by each search,
+// and serves them as Atom, RSS 2.0 and JSON Feed.
+type feedStore struct {
+	size    int
+	baseURL string
+
+	mu    sync.RWMutex
+	items map[string][]feedItem
+}
+
+// feedNotifier adds the products of one search to the feed store.
+type feedNotifier struct {
+	store  *feedStore
+	search string
+}
+
+// newFeedStore creates a store that keeps the last size products per search
+func newFeedStore(size int, baseURL string) *feedStore {
+	return &feedStore{
+		size:    size,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		items:   make(map[string][]feedItem),
+	}
+}
+
+// resize changes how many products are kept per search
+func (f *feedStore) resize(size int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.size = size
+	for name, items := range f.items {
+		if len(items) > size {
+			f.items[name] = items[:size]
+		}
+	}
+}
+
+// Name returns the notifier description
+func (n *feedNotifier) Name() string {
+	return "feed /feeds/" + n.search
+}
+
+// Notify adds the product to the front of the search's feed
+func (n *feedNotifier) Notify(ctx context.Context, p Product) error {
+	n.store.mu.Lock()
+	defer n.store.mu.Unlock()
+	items := append([]feedItem{{product: p, found: time.Now()}}, n.store.items[n.search]...)
+	if len(items) > n.store.size {
+		items = items[:n.store.size]
+	}
+	n.store.items[n.search] = items
+	return nil
+}
+
+// ServeHTTP serves /feeds/<search>.xml (Atom, or RSS 2.0 with ?format=rss) and /feeds/<search>.json (JSON Feed)
+func (f *feedStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	file := path.Base(r.URL.Path)
+	ext := path.Ext(file)
+	name := strings.TrimSuffix(file, ext)
+
+	var known bool
+	for _, s := range currentSearches() {
+		if s.Name == name {
+			known = true
+			break
+		}
+	}
+	if !known || (ext != ".xml" && ext != ".json") {
+		http.NotFound(w, r)
+		return
+	}
+
+	f.mu.RLock()
+	items := make([]feedItem, len(f.items[name]))
+	copy(items, f.items[name])
+	f.mu.RUnlock()
+
+	var body []byte
+	var err error
+	switch {
+	case ext == ".json":
+		w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+		body, err = f.jsonFeed(name, items)
+	case r.URL.Query().Get("format") == "rss":
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		body, err = f.rssFeed(name, items)
+	default:
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		body, err = f.atomFeed(name, items)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(body)
+}
+
+// feedTitle is the title used for every flavour of a search's feed
+func feedTitle(name string) string {
+	return "DMs Guild releases: " + name
+}
+
+// feedUpdated is the time of the newest item, or now for an empty feed
+func feedUpdated(items []feedItem) time.Time {
+	if len(items) == 0 {
+		return time.Now()
+	}
+	return items[0].found
+}
+
+// feedItemID builds a stable ID for a product, using the DMs Guild product ID when we have one
+func feedItemID(p Product) string {
+	if p.ID != "" {
+		return "tag:dmsguild.com,2020:product/" + p.ID
+	}
+	return "tag:dmsguild.com,2020:title/" + p.Title
+}
+
+// feedHTML renders the product as HTML for the body of a feed entry
+func feedHTML(p Product) string {
+	text := ""
+	if p.Image != "" {
+		text = text + `<p><img src="` + html.EscapeString(p.Image) + `" alt=""></p>`
+	}
+	text = text + "<p><strong>Date Added</strong>: " + html.EscapeString(p.DateAdded) + "<br>"
+	text = text + "<strong>Price</strong>: " + html.EscapeString(p.PriceText()) + "</p>"
+	if p.Description != "" {
+		text = text + "<p>" + strings.Replace(html.EscapeString(p.Description), "\n", "<br>", -1) + "</p>"
+	}
+	return text
+}
+
+// atomLink is an Atom link element
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// atomText is an Atom text construct
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// atomEntry is an Atom entry element
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Content atomText `xml:"content"`
+}
+
+// atomFeedDoc is the Atom feed document
+type atomFeedDoc struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomFeed renders the items as an Atom feed
+func (f *feedStore) atomFeed(name string, items []feedItem) ([]byte, error) {
+	doc := atomFeedDoc{
+		Title:   feedTitle(name),
+		ID:      "tag:dmsguild.com,2020:search/" + name,
+		Updated: feedUpdated(items).UTC().Format(time.RFC3339),
+		Author:  "DMs Guild",
+		Links: []atomLink{
+			{Href: f.baseURL + "/feeds/" + name + ".xml", Rel: "self", Type: "application/atom+xml"},
+			{Href: "https://www.dmsguild.com/", Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for _, item := range items {
+		doc.Entries = append(doc.Entries, atomEntry{
+			Title:   item.product.Title,
+			ID:      feedItemID(item.product),
+			Updated: item.found.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: item.product.Link, Rel: "alternate", Type: "text/html"},
+			Content: atomText{Type: "html", Body: feedHTML(item.product)},
+		})
+	}
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// rssGUID is an RSS item guid
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Body        string `xml:",chardata"`
+}
+
+// rssItem is an RSS 2.0 item element
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+// rssFeedDoc is the RSS 2.0 document
+type rssFeedDoc struct {
+	XMLName       xml.Name  `xml:"rss"`
+	Version       string    `xml:"version,attr"`
+	Title         string    `xml:"channel>title"`
+	Link          string    `xml:"channel>link"`
+	Description   string    `xml:"channel>description"`
+	LastBuildDate string    `xml:"channel>lastBuildDate"`
+	Items         []rssItem `xml:"channel>item"`
+}
+
+// rssFeed renders the items as an RSS 2.0 feed
+func (f *feedStore) rssFeed(name string, items []feedItem) ([]byte, error) {
+	doc := rssFeedDoc{
+		Version:       "2.0",
+		Title:         feedTitle(name),
+		Link:          "https://www.dmsguild.com/",
+		Description:   "New DMs Guild releases matched by the " + name + " search",
+		LastBuildDate: feedUpdated(items).Format(time.RFC1123Z),
+	}
+	for _, item := range items {
+		doc.Items = append(doc.Items, rssItem{
+			Title:       item.product.Title,
+			Link:        item.product.Link,
+			GUID:        rssGUID{IsPermaLink: false, Body: feedItemID(item.product)},
+			PubDate:     item.found.Format(time.RFC1123Z),
+			Description: feedHTML(item.product),
+		})
+	}
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// jsonFeedItem is a JSON Feed 1.1 item
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	ContentHTML   string `json:"content_html"`
+	Image         string `json:"image,omitempty"`
+	DatePublished string `json:"date_published"`
+}
+
+// jsonFeedDoc is the JSON Feed 1.1 document
+type jsonFeedDoc struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+// jsonFeed renders the items as a JSON Feed
+func (f *feedStore) jsonFeed(name string, items []feedItem) ([]byte, error) {
+	doc := jsonFeedDoc{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feedTitle(name),
+		HomePageURL: "https://www.dmsguild.com/",
+		Items:       make([]jsonFeedItem, 0, len(items)),
+	}
+	if f.baseURL != "" {
+		doc.FeedURL = f.baseURL + "/feeds/" + name + ".json"
+	}
+	for _, item := range items {
+		doc.Items = append(doc.Items, jsonFeedItem{
+			ID:            feedItemID(item.product),
+			URL:           item.product.Link,
+			Title:         item.product.Title,
+			ContentHTML:   feedHTML(item.product),
+			Image:         item.product.Image,
+			DatePublished: item.found.UTC().Format(time.RFC3339),
+		})
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
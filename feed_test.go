@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// withFeeds sets up the feeds for a configuration with a single search, fg, for the duration of a test
+func withFeeds(t *testing.T, size int) Config {
+	t.Helper()
+	savedSearches, savedFeeds, savedDigest := searches, feeds, digest
+	t.Cleanup(func() {
+		searches, feeds, digest = savedSearches, savedFeeds, savedDigest
+	})
+	feeds = nil
+
+	var conf Config
+	conf.Feeds.Enabled = true
+	conf.Feeds.Size = size
+	conf.Server.BaseURL = "https://bot.example.com/"
+	conf.Searches = []SearchConfig{{Name: "fg", Keywords: "fantasy+grounds"}}
+	if err := setup(conf); err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+// getFeed fetches a path from the feeds and returns the status, content type and body
+func getFeed(t *testing.T, srv *httptest.Server, path string) (int, string, []byte) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header.Get("Content-Type"), body
+}
+
+// addToFeed sends products 1 to n to the search's feed, through its notifier
+func addToFeed(t *testing.T, name string, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		p := Product{ID: strconv.Itoa(i), Title: "Release <" + strconv.Itoa(i) + ">", DateAdded: "2026-10-18", Price: "$1.00",
+			Link: "https://www.dmsguild.com/product/" + strconv.Itoa(i) + "?affiliate_id=42"}
+		if err := findNotifier(name, "feed /feeds/"+name).Notify(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	withFeeds(t, 2)
+	srv := httptest.NewServer(feeds)
+	defer srv.Close()
+	addToFeed(t, "fg", 3)
+
+	status, contentType, body := getFeed(t, srv, "/feeds/fg.xml")
+	if status != http.StatusOK || !strings.HasPrefix(contentType, "application/atom+xml") {
+		t.Fatalf("atom: %d %s", status, contentType)
+	}
+	var atom atomFeedDoc
+	if err := xml.Unmarshal(body, &atom); err != nil {
+		t.Fatal(err)
+	}
+	// Newest first, and only the last feeds.size products
+	if len(atom.Entries) != 2 || atom.Entries[0].Title != "Release <3>" || atom.Entries[1].ID != "tag:dmsguild.com,2020:product/2" {
+		t.Errorf("unexpected atom entries %+v", atom.Entries)
+	}
+	if atom.Links[0].Href != "https://bot.example.com/feeds/fg.xml" {
+		t.Errorf("self link %q", atom.Links[0].Href)
+	}
+
+	status, contentType, body = getFeed(t, srv, "/feeds/fg.xml?format=rss")
+	if status != http.StatusOK || !strings.HasPrefix(contentType, "application/rss+xml") {
+		t.Fatalf("rss: %d %s", status, contentType)
+	}
+	var rss rssFeedDoc
+	if err := xml.Unmarshal(body, &rss); err != nil {
+		t.Fatal(err)
+	}
+	if rss.Version != "2.0" || len(rss.Items) != 2 || rss.Items[0].Link != "https://www.dmsguild.com/product/3?affiliate_id=42" {
+		t.Errorf("unexpected rss feed %+v", rss)
+	}
+
+	status, contentType, body = getFeed(t, srv, "/feeds/fg.json")
+	if status != http.StatusOK || !strings.HasPrefix(contentType, "application/feed+json") {
+		t.Fatalf("json: %d %s", status, contentType)
+	}
+	var feed jsonFeedDoc
+	if err := json.Unmarshal(body, &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.FeedURL != "https://bot.example.com/feeds/fg.json" || len(feed.Items) != 2 || !strings.Contains(feed.Items[0].ContentHTML, "$1.00") {
+		t.Errorf("unexpected json feed %+v", feed)
+	}
+
+	for _, path := range []string{"/feeds/other.xml", "/feeds/other.json", "/feeds/fg.rss", "/feeds/fg"} {
+		if status, _, _ := getFeed(t, srv, path); status != http.StatusNotFound {
+			t.Errorf("%s: got %d, want 404", path, status)
+		}
+	}
+}
+
+func TestFeedsEmpty(t *testing.T) {
+	withFeeds(t, 2)
+	srv := httptest.NewServer(feeds)
+	defer srv.Close()
+
+	_, _, body := getFeed(t, srv, "/feeds/fg.json")
+	var feed jsonFeedDoc
+	if err := json.Unmarshal(body, &feed); err != nil {
+		t.Fatal(err)
+	}
+	// An empty list rather than null, which JSON Feed readers don't accept
+	if feed.Items == nil || len(feed.Items) != 0 {
+		t.Errorf("unexpected items %v", feed.Items)
+	}
+}
+
+func TestFeedsResizeOnReload(t *testing.T) {
+	conf := withFeeds(t, 5)
+	store := feeds
+	addToFeed(t, "fg", 4)
+
+	conf.Feeds.Size = 2
+	if err := setup(conf); err != nil {
+		t.Fatal(err)
+	}
+	if feeds != store {
+		t.Fatal("a reload replaced the feeds, losing their items")
+	}
+	items := feeds.items["fg"]
+	if len(items) != 2 || items[0].product.ID != "4" || items[1].product.ID != "3" {
+		t.Fatalf("kept %+v, want the newest two", items)
+	}
+
+	// The new size also applies to what is added afterwards
+	conf.Feeds.Size = 3
+	if err := setup(conf); err != nil {
+		t.Fatal(err)
+	}
+	addToFeed(t, "fg", 2)
+	if items := feeds.items["fg"]; len(items) != 3 || items[0].product.ID != "2" || items[2].product.ID != "4" {
+		t.Errorf("got %+v", items)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/spkane/discord_bot_dmsguild_search/dmsguild"
+)
+
+// hostLimiters are the rate limiters of the hosts the bot searches, shared by every
+// search and kept across reloads, so a host never sees more than polling.requests_per_second.
+var hostLimitersMu sync.Mutex
+var hostLimiters = make(map[string]*dmsguild.TokenBucket)
+
+// hostLimiter returns the rate limiter of the host of baseURL, set to rate and burst
+func hostLimiter(baseURL string, rate float64, burst int) *dmsguild.TokenBucket {
+	host := baseURL
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	hostLimitersMu.Lock()
+	defer hostLimitersMu.Unlock()
+	limiter, ok := hostLimiters[host]
+	if !ok {
+		limiter = dmsguild.NewTokenBucket(rate, burst)
+		hostLimiters[host] = limiter
+		return limiter
+	}
+	limiter.SetRate(rate, burst)
+	return limiter
+}
+
+// fetchResult is the outcome of fetching a single search
+type fetchResult struct {
+	page     *dmsguild.Page
+	err      error
+	start    time.Time
+	attempts int
+}
+
+// fetchAll fetches the searches in list concurrently, with at most workers at a time.
+// The results are in the same order as list, so they can be posted in a fixed order.
+func fetchAll(ctx context.Context, list []*search, workers int) []fetchResult {
+	results := make([]fetchResult, len(list))
+	if workers > len(list) {
+		workers = len(list)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = fetchOne(ctx, list[i])
+			}
+		}()
+	}
+	for i := range list {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// fetchOne fetches a single search. A panic only fails this search, not the others.
+func fetchOne(ctx context.Context, s *search) (r fetchResult) {
+	r.start = time.Now()
+	defer func() {
+		if p := recover(); p != nil {
+			r.page = nil
+			r.err = fmt.Errorf("panic while fetching search results: %v", p)
+		}
+	}()
+	r.page, r.attempts, r.err = fetchPage(ctx, s)
+	return r
+}
@@ -7,4 +7,5 @@ require (
 	github.com/bwmarrin/discordgo v0.22.0
 	github.com/ilyakaznacheev/cleanenv v1.2.5
 	github.com/jasonlvhit/gocron v0.0.1
+	golang.org/x/net v0.0.0-20200226121028-0de0cce0169b
 )
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// healthState tracks what /readyz needs to know: when each search last worked,
+// and whether Discord is accepting our token.
+type healthState struct {
+	mu          sync.Mutex
+	started     time.Time
+	staleFactor int
+	lastSuccess map[string]time.Time
+	lastError   map[string]string
+	discordAuth string
+}
+
+// searchHealth is the readiness detail of a single search
+type searchHealth struct {
+	OK          bool   `json:"ok"`
+	LastSuccess string `json:"last_success,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// readiness is the body of the /readyz response
+type readiness struct {
+	Status   string                  `json:"status"`
+	Discord  searchHealth            `json:"discord"`
+	Searches map[string]searchHealth `json:"searches"`
+}
+
+// health is the application's health state
+var health = &healthState{
+	started:     time.Now(),
+	staleFactor: 3,
+	lastSuccess: make(map[string]time.Time),
+	lastError:   make(map[string]string),
+}
+
+// configure sets how many of its poll intervals a search may go without success
+func (h *healthState) configure(staleFactor int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.staleFactor = staleFactor
+}
+
+// pollResult records the outcome of polling a search
+func (h *healthState) pollResult(searchName string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.lastError[searchName] = redactError(err)
+		return
+	}
+	h.lastSuccess[searchName] = time.Now()
+	delete(h.lastError, searchName)
+}
+
+// discordResult records the outcome of a Discord API call.
+// Only authentication failures mark Discord as unhealthy, a success clears that again.
+func (h *healthState) discordResult(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.discordAuth = ""
+		return
+	}
+	if restErr, ok := err.(*discordgo.RESTError); ok && restErr.Response != nil && restErr.Response.StatusCode == http.StatusUnauthorized {
+		h.discordAuth = redactError(err)
+	}
+}
+
+// check works out the readiness of the bot. A search is stale when it hasn't worked for
+// staleFactor times its poll interval. Before the first success, the time since startup is used.
+func (h *healthState) check(now time.Time) readiness {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := readiness{Status: "ok", Discord: searchHealth{OK: true}, Searches: make(map[string]searchHealth)}
+	if h.discordAuth != "" {
+		result.Status = "unavailable"
+		result.Discord = searchHealth{OK: false, LastError: h.discordAuth, Reason: "Discord authentication is failing"}
+	}
+
+	conf := currentConfig()
+	for _, s := range currentSearches() {
+		limit := time.Duration(h.staleFactor) * s.pollInterval(conf)
+		detail := searchHealth{OK: true, LastError: h.lastError[s.Name]}
+		since := h.started
+		if last, ok := h.lastSuccess[s.Name]; ok {
+			since = last
+			detail.LastSuccess = last.Format(time.RFC3339)
+		}
+		if limit > 0 && now.Sub(since) > limit {
+			detail.OK = false
+			detail.Reason = "no successful poll in " + now.Sub(since).Round(time.Second).String()
+			result.Status = "unavailable"
+		}
+		result.Searches[s.Name] = detail
+	}
+	return result
+}
+
+// serveHealthz answers as long as the process is running
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}` + "\n"))
+}
+
+// serveReadyz answers 200 when every search is polling and Discord accepts our token, and 503 otherwise
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	result := health.check(time.Now())
+	body, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if result.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(append(body, '\n'))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// withConfig replaces the configuration for the duration of a test
+func withConfig(t *testing.T, conf Config) {
+	t.Helper()
+	cfgMu.Lock()
+	saved := cfg
+	cfg = conf
+	cfgMu.Unlock()
+	t.Cleanup(func() {
+		cfgMu.Lock()
+		cfg = saved
+		cfgMu.Unlock()
+	})
+}
+
+func TestHealthStale(t *testing.T) {
+	every := newTestSearch("every")
+	cron := newTestSearch("cron")
+	var err error
+	if cron.cron, err = parseCron("0 9 * * mon-fri"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	// Friday 9:00 to Monday 9:00
+	cron.cronGap = cron.cron.maxGap(start)
+	withSearches(t, every, cron)
+
+	var conf Config
+	conf.Settings.Minutes = 15
+	conf.Polling.MaxMinutes = 60
+
+	tests := []struct {
+		adaptive bool
+		after    time.Duration
+		stale    []string
+	}{
+		// 3 times settings.minutes
+		{after: 44 * time.Minute},
+		{after: 46 * time.Minute, stale: []string{"every"}},
+		// 3 times polling.max_minutes
+		{adaptive: true, after: 179 * time.Minute},
+		{adaptive: true, after: 181 * time.Minute, stale: []string{"every"}},
+		// 3 times the three days over the weekend
+		{after: 9*24*time.Hour - time.Minute, stale: []string{"every"}},
+		{after: 9*24*time.Hour + time.Minute, stale: []string{"every", "cron"}},
+	}
+	for _, tt := range tests {
+		conf.Polling.Adaptive = tt.adaptive
+		withConfig(t, conf)
+		h := &healthState{started: start, staleFactor: 3, lastSuccess: map[string]time.Time{}, lastError: map[string]string{}}
+		h.lastSuccess["every"] = start
+		h.lastSuccess["cron"] = start
+
+		r := h.check(start.Add(tt.after))
+		var stale []string
+		for _, name := range []string{"every", "cron"} {
+			if !r.Searches[name].OK {
+				stale = append(stale, name)
+			}
+		}
+		if !equalStrings(stale, tt.stale) {
+			t.Errorf("adaptive %v after %s: stale %v, want %v", tt.adaptive, tt.after, stale, tt.stale)
+		}
+		if want := "ok"; len(tt.stale) > 0 && r.Status == want || len(tt.stale) == 0 && r.Status != want {
+			t.Errorf("adaptive %v after %s: status %s", tt.adaptive, tt.after, r.Status)
+		}
+	}
+}
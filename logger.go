@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the severity of a log entry
+type logLevel int
+
+// The log levels, from most to least verbose
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// levelNames are the names used in the output and the configuration
+var levelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+// Logger writes leveled log entries with key/value fields, as text or as JSON lines.
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level logLevel
+	json  bool
+}
+
+// logger is used for all of the application's logging.
+// It logs text at info level until it is configured.
+var logger = &Logger{out: os.Stdout, level: levelInfo}
+
+// Configure sets the minimum level (debug, info, warn or error) and the format (text or json).
+func (l *Logger) Configure(level, format string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	found := false
+	for lvl, name := range levelNames {
+		if strings.EqualFold(level, name) {
+			l.level = lvl
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
+	}
+
+	switch strings.ToLower(format) {
+	case "text", "":
+		l.json = false
+	case "json":
+		l.json = true
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	return nil
+}
+
+// SetOutput changes where the log is written
+func (l *Logger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = w
+}
+
+// Debug logs a message that is only interesting while debugging.
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(levelDebug, msg, kv)
+}
+
+// Info logs a routine message.
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(levelInfo, msg, kv)
+}
+
+// Warn logs something that went wrong, but was handled.
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.log(levelWarn, msg, kv)
+}
+
+// Error logs something that went wrong and needs attention.
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(levelError, msg, kv)
+}
+
+// DebugEnabled reports if debug entries are written, to skip building expensive fields.
+func (l *Logger) DebugEnabled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level <= levelDebug
+}
+
+// log writes a single entry. kv is a list of alternating keys and values.
+func (l *Logger) log(level logLevel, msg string, kv []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return
+	}
+
+	now := time.Now()
+	if l.json {
+		entry := map[string]interface{}{
+			"time":  now.Format(time.RFC3339Nano),
+			"level": levelNames[level],
+			"msg":   redact(msg),
+		}
+		for i := 0; i < len(kv); i += 2 {
+			entry[logKey(kv, i)] = logJSONValue(logValue(kv, i))
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			line = []byte(`{"level":"error","msg":"could not encode log entry"}`)
+		}
+		io.WriteString(l.out, string(line)+"\n")
+		return
+	}
+
+	line := now.Format("2006-01-02T15:04:05.000Z07:00") + " [" + strings.ToUpper(levelNames[level]) + "] " + redact(msg)
+	for i := 0; i < len(kv); i += 2 {
+		line = line + " " + logKey(kv, i) + "=" + logTextValue(logValue(kv, i))
+	}
+	io.WriteString(l.out, line+"\n")
+}
+
+// logKey returns the key at position i of a key/value list
+func logKey(kv []interface{}, i int) string {
+	if key, ok := kv[i].(string); ok {
+		return key
+	}
+	return fmt.Sprint(kv[i])
+}
+
+// logValue returns the value for the key at position i, tolerating a missing value
+func logValue(kv []interface{}, i int) interface{} {
+	if i+1 < len(kv) {
+		return kv[i+1]
+	}
+	return "(missing)"
+}
+
+// logJSONValue converts values that don't encode well as JSON, and redacts the secrets in them.
+// This has to happen before encoding, since JSON escapes characters like & and < that secrets may contain.
+func logJSONValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case nil, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return v
+	case string:
+		return redact(value)
+	case error:
+		return redact(value.Error())
+	case time.Duration:
+		return value.String()
+	case fmt.Stringer:
+		return redact(value.String())
+	}
+	return redact(fmt.Sprint(v))
+}
+
+// logTextValue formats a value for the text format, quoting it if it has spaces or quotes.
+// Secrets are redacted before quoting, which escapes quotes and backslashes.
+func logTextValue(v interface{}) string {
+	var s string
+	switch value := v.(type) {
+	case string:
+		s = value
+	case error:
+		s = value.Error()
+	default:
+		s = fmt.Sprint(value)
+	}
+	s = redact(s)
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// withSecrets registers secrets for the duration of a test
+func withSecrets(t *testing.T, values ...string) {
+	t.Helper()
+	secretsMu.Lock()
+	saved := secretValues
+	secretValues = make(map[string]bool)
+	for _, v := range values {
+		secretValues[v] = true
+	}
+	secretsMu.Unlock()
+	t.Cleanup(func() {
+		secretsMu.Lock()
+		secretValues = saved
+		secretsMu.Unlock()
+	})
+}
+
+func TestLoggerRedactsSecrets(t *testing.T) {
+	// Every character JSON or strconv.Quote escapes
+	secret := `pa&ss<word>"\`
+	withSecrets(t, secret)
+
+	for _, format := range []string{"text", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var out bytes.Buffer
+			l := &Logger{out: &out}
+			if err := l.Configure("debug", format); err != nil {
+				t.Fatal(err)
+			}
+			l.Info("sending with "+secret, "token", secret, "error", errors.New("login as "+secret+" failed"), "count", 3)
+
+			line := out.String()
+			for _, leak := range []string{"pa&ss", `pa\u0026ss`, "word"} {
+				if strings.Contains(line, leak) {
+					t.Fatalf("secret was logged: %s", line)
+				}
+			}
+			if strings.Count(line, redacted) != 3 {
+				t.Fatalf("expected 3 redactions in %s", line)
+			}
+			if format == "json" {
+				var entry map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("not a JSON line: %v: %s", err, line)
+				}
+				if entry["token"] != redacted || entry["count"] != float64(3) {
+					t.Fatalf("unexpected fields: %v", entry)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactLongestFirst(t *testing.T) {
+	withSecrets(t, "abc", "abcdef")
+	if got := redact("x abcdef y abc"); got != "x "+redacted+" y "+redacted {
+		t.Fatalf("got %q", got)
+	}
+}
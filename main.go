@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
@@ -12,10 +15,10 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/anaskhan96/soup"
 	"github.com/bwmarrin/discordgo"
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/jasonlvhit/gocron"
+	"github.com/spkane/discord_bot_dmsguild_search/dmsguild"
 )
 
 // Config is the type definition for the YAML configuration
@@ -23,89 +26,376 @@ import (
 // set the value to empty string in the config :(
 type Config struct {
 	Discord struct {
-		Token   string `yaml:"token" env:"DISCORD_TOKEN"`
-		Channel string `yaml:"channel" env:"DISCORD_CHANNEL_ID"`
+		Token     string `yaml:"token" env:"DISCORD_TOKEN"`
+		TokenFile string `yaml:"token_file" env:"DISCORD_TOKEN_FILE"`
+		Channel   string `yaml:"channel" env:"DISCORD_CHANNEL_ID"`
 	} `yaml:"discord"`
 	Dmsguild struct {
 		Affiliate   string `yaml:"affiliate" env:"DMG_AFFILIATE_ID" env-default:"563484"`
+		BaseURL     string `yaml:"base_url" env:"DMG_BASE_URL" env-default:"https://www.dmsguild.com"`
 		Keywords    string `yaml:"keywords" env:"DMG_SEARCH_KEYWORDS" env-default:"fantasy%20grounds"`
 		TitleFilter string `yaml:"title_filter" env:"DMG_TITLE_FILTER"`
+		TitleRegex  string `yaml:"title_regex" env:"DMG_TITLE_REGEX"`
+		Schedule    string `yaml:"schedule" env:"DMG_SCHEDULE"`
+		Timezone    string `yaml:"timezone" env:"DMG_TIMEZONE"`
+		QuietHours  string `yaml:"quiet_hours" env:"DMG_QUIET_HOURS"`
 	} `yaml:"dmsguild"`
+	Slack struct {
+		WebhookURL     string `yaml:"webhook_url" env:"SLACK_WEBHOOK_URL"`
+		WebhookURLFile string `yaml:"webhook_url_file" env:"SLACK_WEBHOOK_URL_FILE"`
+	} `yaml:"slack"`
+	Matrix struct {
+		Homeserver string `yaml:"homeserver" env:"MATRIX_HOMESERVER"`
+		Token      string `yaml:"token" env:"MATRIX_ACCESS_TOKEN"`
+		TokenFile  string `yaml:"token_file" env:"MATRIX_ACCESS_TOKEN_FILE"`
+		Room       string `yaml:"room_id" env:"MATRIX_ROOM_ID"`
+	} `yaml:"matrix"`
+	Email struct {
+		Host         string   `yaml:"host" env:"SMTP_HOST"`
+		Port         int      `yaml:"port" env:"SMTP_PORT" env-default:"587"`
+		Username     string   `yaml:"username" env:"SMTP_USERNAME"`
+		Password     string   `yaml:"password" env:"SMTP_PASSWORD"`
+		PasswordFile string   `yaml:"password_file" env:"SMTP_PASSWORD_FILE"`
+		From         string   `yaml:"from" env:"EMAIL_FROM"`
+		Subject      string   `yaml:"subject" env:"EMAIL_SUBJECT" env-default:"New DMs Guild releases"`
+		Subscribers  []string `yaml:"subscribers" env:"EMAIL_SUBSCRIBERS"`
+		SendAt       string   `yaml:"send_at" env:"EMAIL_SEND_AT" env-default:"18:00"`
+	} `yaml:"email"`
+	Webhook struct {
+		URLs       []string `yaml:"urls" env:"WEBHOOK_URLS"`
+		Secret     string   `yaml:"secret" env:"WEBHOOK_SECRET"`
+		SecretFile string   `yaml:"secret_file" env:"WEBHOOK_SECRET_FILE"`
+	} `yaml:"webhook"`
+	Mastodon struct {
+		Instance   string   `yaml:"instance" env:"MASTODON_INSTANCE"`
+		Token      string   `yaml:"token" env:"MASTODON_TOKEN"`
+		TokenFile  string   `yaml:"token_file" env:"MASTODON_TOKEN_FILE"`
+		Visibility string   `yaml:"visibility" env:"MASTODON_VISIBILITY" env-default:"public"`
+		Hashtags   []string `yaml:"hashtags" env:"MASTODON_HASHTAGS"`
+	} `yaml:"mastodon"`
+	Telegram struct {
+		Token     string `yaml:"token" env:"TELEGRAM_BOT_TOKEN"`
+		TokenFile string `yaml:"token_file" env:"TELEGRAM_BOT_TOKEN_FILE"`
+		APIURL    string `yaml:"api_url" env:"TELEGRAM_API_URL" env-default:"https://api.telegram.org"`
+		ChatID    string `yaml:"chat_id" env:"TELEGRAM_CHAT_ID"`
+	} `yaml:"telegram"`
+	Server struct {
+		Listen  string `yaml:"listen" env:"HTTP_LISTEN"`
+		BaseURL string `yaml:"base_url" env:"HTTP_BASE_URL"`
+	} `yaml:"server"`
+	Feeds struct {
+		Enabled bool `yaml:"enabled" env:"FEEDS_ENABLED"`
+		Size    int  `yaml:"size" env:"FEEDS_SIZE" env-default:"50"`
+	} `yaml:"feeds"`
+	Metrics struct {
+		Enabled bool `yaml:"enabled" env:"METRICS_ENABLED"`
+	} `yaml:"metrics"`
+	Health struct {
+		Enabled     bool `yaml:"enabled" env:"HEALTH_ENABLED"`
+		StaleFactor int  `yaml:"stale_factor" env:"HEALTH_STALE_FACTOR" env-default:"3"`
+	} `yaml:"health"`
+	Alerts struct {
+		Channel string   `yaml:"channel" env:"ALERTS_CHANNEL"`
+		Users   []string `yaml:"users" env:"ALERTS_USERS"`
+		// After is how many failures in a row of a search or sink it takes to post an alert
+		After int `yaml:"after" env:"ALERTS_AFTER" env-default:"3"`
+		// MinInterval is how often an alert may be repeated while the failures go on
+		MinInterval time.Duration `yaml:"min_interval" env:"ALERTS_MIN_INTERVAL" env-default:"1h"`
+	} `yaml:"alerts"`
+	Polling struct {
+		Adaptive   bool          `yaml:"adaptive" env:"POLL_ADAPTIVE"`
+		MinMinutes Minutes       `yaml:"min_minutes" env:"POLL_MIN_MINUTES" env-default:"5"`
+		MaxMinutes Minutes       `yaml:"max_minutes" env:"POLL_MAX_MINUTES" env-default:"60"`
+		Jitter     time.Duration `yaml:"jitter" env:"POLL_JITTER" env-default:"30s"`
+		// Workers is how many searches are fetched at the same time
+		Workers int `yaml:"workers" env:"POLL_WORKERS" env-default:"4"`
+		// RequestsPerSecond limits the requests to DMs Guild, shared by every search. 0 turns it off.
+		RequestsPerSecond float64 `yaml:"requests_per_second" env:"POLL_REQUESTS_PER_SECOND" env-default:"1"`
+		Burst             int     `yaml:"burst" env:"POLL_BURST" env-default:"2"`
+	} `yaml:"polling"`
 	Settings struct {
-		Minutes string `yaml:"minutes" env:"CHECK_MINUTES" env-default:"15"`
+		Minutes     Minutes `yaml:"minutes" env:"CHECK_MINUTES" env-default:"15"`
+		StateFile   string  `yaml:"state_file" env:"STATE_FILE" env-default:"state.json"`
+		MaxAttempts int     `yaml:"max_attempts" env:"MAX_ATTEMPTS" env-default:"10"`
+		Quarantine  string  `yaml:"quarantine_dir" env:"QUARANTINE_DIR" env-default:"quarantine"`
+		LogLevel    string  `yaml:"log_level" env:"LOG_LEVEL" env-default:"info"`
+		LogFormat   string  `yaml:"log_format" env:"LOG_FORMAT" env-default:"text"`
+		// ShutdownTimeout is how long to wait for a running check or email digest to finish when stopping
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" env-default:"30s"`
+		// CycleTimeout is how long a check, from fetching to posting, may take before it is cancelled
+		CycleTimeout time.Duration `yaml:"cycle_timeout" env:"CYCLE_TIMEOUT" env-default:"5m"`
+		// ReloadInterval is how often to check the config file for changes, 0 turns that off
+		ReloadInterval time.Duration `yaml:"reload_interval" env:"RELOAD_INTERVAL" env-default:"10s"`
 	} `yaml:"settings"`
+	Searches []SearchConfig `yaml:"searches"`
+}
+
+// SearchConfig describes a single DMs Guild search and the places its results are posted.
+// If no searches are configured, a single "default" search is built from
+// the dmsguild, discord, slack, matrix, email, webhook, mastodon and telegram sections.
+type SearchConfig struct {
+	Name        string `yaml:"name"`
+	Keywords    string `yaml:"keywords"`
+	TitleFilter string `yaml:"title_filter"`
+	TitleRegex  string `yaml:"title_regex"`
+	// Schedule is a cron expression for when to poll, instead of every settings.minutes
+	Schedule   string `yaml:"schedule"`
+	Timezone   string `yaml:"timezone"`
+	QuietHours string `yaml:"quiet_hours"`
+	Discord    struct {
+		Channel string `yaml:"channel"`
+	} `yaml:"discord"`
+	Slack struct {
+		WebhookURL     string `yaml:"webhook_url"`
+		WebhookURLFile string `yaml:"webhook_url_file"`
+	} `yaml:"slack"`
+	Matrix struct {
+		Room string `yaml:"room_id"`
+	} `yaml:"matrix"`
+	Email struct {
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"email"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	Mastodon struct {
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"mastodon"`
+	Telegram struct {
+		ChatID string `yaml:"chat_id"`
+	} `yaml:"telegram"`
+}
+
+// WebhookConfig is a URL that matched products are POSTed to as signed JSON.
+type WebhookConfig struct {
+	URL        string `yaml:"url"`
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+}
+
+// search is a configured search along with the notifiers that its results are sent to.
+type search struct {
+	SearchConfig
+	titleRegex *regexp.Regexp
+	notifiers  []Notifier
+	// found is how many new products the last poll found
+	found int
+
+	cron     *cronSchedule
+	cronGap  time.Duration
+	location *time.Location
+	quiet    *quietHours
+}
+
+// matchTitle reports whether a title passes the search's title filter and title regex.
+// Useful for "Fantasy Grounds" amoung others.
+func (s *search) matchTitle(title string) bool {
+	if s.TitleFilter != "" && !strings.Contains(title, s.TitleFilter) {
+		return false
+	}
+	return s.titleRegex == nil || s.titleRegex.MatchString(title)
 }
 
 // Args command-line parameters
 type Args struct {
-	ConfigPath string
+	Command      string
+	Rest         []string
+	ConfigPath   string
+	DeadLetters  bool
+	CheckConfig  bool
+	CheckDiscord bool
+	DryRun       bool
+	DryRunOutput string
+	Format       string
+	TitleFilter  string
+	TitleRegex   string
+}
+
+// commandHelp describes the commands, in the order they are listed in the usage.
+// run is the default.
+var commandHelp = [][2]string{
+	{"run", "Check for new releases and post them, forever (the default)"},
+	{"once", "Check for new releases and post them once, then exit"},
+	{"search \"terms\"", "Print the products DMs Guild finds for the terms, without posting them"},
+	{"validate", "Check the configuration and exit (same as -check-config)"},
+	{"state list", "Print what was delivered today, and what is queued or was given up on"},
+	{"state forget <id>", "Forget a product (by ID or title), so it is posted again"},
+	{"state reset", "Forget everything in the state file"},
 }
 
 // global variables
 var discord *discordgo.Session
 var cfg Config
+var searches []*search
+var digest *emailDigest
+var feeds *feedStore
+var box *outbox
 var memoryDate string
-var memoryTitles []string
 
-// init Initializes a few paramaters and sets up signal handling
+// client searches DMs Guild
+var client *dmsguild.Client
+
+// cycleMu is read locked while a check runs, so shutdown and reloads can wait for every check to finish
+var cycleMu sync.RWMutex
+
+// digestMu is held while the email digest is being sent, so shutdown and reloads can wait for it
+var digestMu sync.Mutex
+
+// queueMu is held while the results of a check are queued, so checks that run side by side
+// queue their products one after the other
+var queueMu sync.Mutex
+
+// cfgMu guards cfg for readers outside of a check, like the scheduler and the HTTP handlers.
+// Checks don't need it, a reload waits for them to finish before swapping in the new configuration.
+var cfgMu sync.RWMutex
+
+// currentConfig returns a snapshot of the configuration
+func currentConfig() Config {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return cfg
+}
+
+// searchesMu guards searches for readers outside of a check, like the HTTP handlers
+var searchesMu sync.RWMutex
+
+// currentSearches returns the searches that are configured right now
+func currentSearches() []*search {
+	searchesMu.RLock()
+	defer searchesMu.RUnlock()
+	return searches
+}
+
+// init Initializes a few paramaters
 func init() {
 	currentTime := time.Now()
 	memoryDate = currentTime.Format("2006-01-02")
-	memoryTitles = make([]string, 0)
-
-	// Setup our Signal handler
-	SetupSignalHandler()
 }
 
 // SetupSignalHandler creates a 'listener' on a new goroutine which will notify the
-// program if it receives an interrupt from the OS. We then handle this by calling
-// our clean up procedure and exiting the program.
-func SetupSignalHandler() {
-	c := make(chan os.Signal)
+// program if it receives an interrupt from the OS. The returned context is cancelled
+// on the first signal, so we can finish what we are doing and clean up.
+// A second signal exits straight away.
+func SetupSignalHandler() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
-		<-c
-		fmt.Println("\n[" + time.Now().String() + "] [WARN] Received signal. Exiting...")
+		sig := <-c
+		logger.Warn("received signal, shutting down", "signal", sig)
+		cancel()
+		sig = <-c
+		logger.Warn("received second signal, exiting immediately", "signal", sig)
 		os.Exit(99)
 	}()
+	return ctx
+}
+
+// shutdown stops scheduling new checks, waits (up to timeout) for a check, delivery
+// or email digest that is already running, saves the state and disconnects from everything.
+// The running work is only cancelled, with stopWork, once the timeout is up.
+func shutdown(sched *scheduler, srv *http.Server, timeout time.Duration, stopWork context.CancelFunc) {
+	logger.Info("shutting down", "timeout", timeout)
+	sched.stop()
+	defer stopWork()
+
+	deadline := time.Now().Add(timeout)
+	done := make(chan struct{})
+	go func() {
+		cycleMu.Lock()
+		box.deliverMu.Lock()
+		digestMu.Lock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logger.Warn("timed out waiting for the current check or digest to finish, cancelling it")
+		stopWork()
+	}
+
+	if err := box.flush(); err != nil {
+		logger.Error("could not save state file", "path", cfg.Settings.StateFile, "error", err)
+	}
+
+	if srv != nil {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline.Add(5*time.Second))
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Warn("could not shut down HTTP server cleanly", "error", err)
+		}
+	}
+
+	if err := discord.Close(); err != nil {
+		logger.Warn("could not close Discord session", "error", err)
+	}
+	logger.Info("shut down")
 }
 
 // ProcessArgs processes and handles CLI arguments
-func ProcessArgs(cfg interface{}) Args {
+func ProcessArgs() Args {
 	var a Args
 
 	f := flag.NewFlagSet("Discord Bot", 1)
 	f.StringVar(&a.ConfigPath, "c", "config.yaml", "Path to configuration file")
+	f.StringVar(&a.Format, "format", "table", "Output of the search command: table or json")
+	f.StringVar(&a.TitleFilter, "title-filter", "", "With search, only print titles containing this text")
+	f.StringVar(&a.TitleRegex, "title-regex", "", "With search, only print titles matching this regular expression")
+	f.BoolVar(&a.DeadLetters, "dead-letters", false, "List the products that could not be delivered and exit")
+	f.BoolVar(&a.CheckConfig, "check-config", false, "Check the configuration, print a report and exit")
+	f.BoolVar(&a.CheckDiscord, "check-discord", false, "With -check-config, also log in to Discord and check access to the channels")
+	f.BoolVar(&a.DryRun, "dry-run", false, "Run every search once and print the messages instead of posting them")
+	f.StringVar(&a.DryRunOutput, "dry-run-output", "-", "With -dry-run, write the messages to this file instead of standard output")
 
-	fu := f.Usage
 	f.Usage = func() {
-		fu()
-		envHelp, _ := cleanenv.GetDescription(cfg, nil)
+		fmt.Fprintln(f.Output(), "Usage: discord_bot_dmsguild_search [command] [flags]")
+		fmt.Fprintln(f.Output())
+		fmt.Fprintln(f.Output(), "Commands:")
+		for _, c := range commandHelp {
+			fmt.Fprintf(f.Output(), "  %-20s %s\n", c[0], c[1])
+		}
+		fmt.Fprintln(f.Output())
+		fmt.Fprintln(f.Output(), "Flags:")
+		f.PrintDefaults()
+		// Only the names and defaults, never the values that are set
+		envHelp, _ := cleanenv.GetDescription(&Config{}, nil)
 		fmt.Fprintln(f.Output())
 		fmt.Fprintln(f.Output(), envHelp)
 	}
 
-	err := f.Parse(os.Args[1:])
+	argv := os.Args[1:]
+	if len(argv) > 0 && !strings.HasPrefix(argv[0], "-") {
+		a.Command = argv[0]
+		argv = argv[1:]
+	}
+	err := f.Parse(argv)
+	// Flags may come before or after the command's arguments
+	for err == nil && f.NArg() > 0 {
+		a.Rest = append(a.Rest, f.Arg(0))
+		err = f.Parse(f.Args()[1:])
+	}
 	if err != nil {
-		fmt.Println("["+time.Now().String()+"] [ERROR] could not parse CLI arguments: ", err)
+		logger.Error("could not parse CLI arguments", "error", err)
 		os.Exit(2)
 	}
-	return a
-}
+	if a.Command == "" && len(a.Rest) > 0 {
+		a.Command, a.Rest = a.Rest[0], a.Rest[1:]
+	}
 
-// removeClick removes the [click here for more...] text, if it exists
-func removeClick(s string) string {
-	fields := strings.Fields(strings.TrimSpace(s))
-	workLine := ""
-	for _, v := range fields {
-		if v == "[click" {
-			break
-		} else {
-			workLine = workLine + v + " "
-		}
+	// The flags from before there were commands
+	switch {
+	case a.CheckConfig:
+		a.Command = "validate"
+	case a.Command == "":
+		a.Command = "run"
 	}
-	final := disableURL(workLine)
-	return strings.TrimSpace(final)
+
+	switch a.Command {
+	case "run", "once", "search", "validate", "state":
+	default:
+		logger.Error("unknown command", "command", a.Command)
+		f.Usage()
+		os.Exit(2)
+	}
+	return a
 }
 
 // disableURL removes http:// and https:// from the descriptions
@@ -126,210 +416,453 @@ func priceClean(s string) string {
 		} else if i == 1 {
 			price = price + "\n**Sales  Price**: " + v
 		} else {
-			fmt.Println("[" + time.Now().String() + "] [WARN] Found more product price lines then expected.")
+			logger.Warn("found more product price lines than expected", "price", s)
 		}
 	}
 	return strings.TrimSpace(price)
 }
 
-// searchRows does the initial search and returns the rows we care about
-func searchRows() ([]soup.Root, error) {
-	resp, err := soup.Get("https://www.dmsguild.com/browse.php?keywords=" + cfg.Dmsguild.Keywords + "&page=1&sort=4a")
+// searchPage does the search and returns the first page of the results
+func searchPage(ctx context.Context, s *search) (*dmsguild.Page, error) {
+	start := time.Now()
+	url := searchURL(s)
+	page, err := client.SearchPage(ctx, query(s))
 	if err != nil {
-		fmt.Println("["+time.Now().String()+"] [ERROR] could perform DMs Guild search: ", err)
+		logger.Debug("could not perform DMs Guild search", "search", s.Name, "url", url, "duration", time.Since(start), "error", err)
 		return nil, err
 	}
-	doc := soup.HTMLParse(resp)
-	return doc.Find("table", "class", "productListing").FindAll("tr"), nil
-}
-
-// handleTitleLine tries to untagle the title and release date
-// and then sets up the message template
-// FIXME: Could still use some refactoring.
-func handleTitleLine(data map[string]string, s string) (map[string]string, error) {
-	data["firstLine"] = "true"
-	d := regexp.MustCompile(` *Date Added: .*$*`)
-	title := d.Split(strings.TrimSpace(s), -1)
-	// Filter the titles.
-	// Useful for "Fantasy Grounds" amoung others.
-	if (cfg.Dmsguild.TitleFilter == "") || (cfg.Dmsguild.TitleFilter != "" && strings.Contains(title[0], cfg.Dmsguild.TitleFilter)) {
-		date := strings.Fields(strings.TrimSpace(s))
-		finalDate := ""
-		endText := ""
-		foundDate := "false"
-		data["sendMessage"] = "true"
-		// DMs Guild HTML code is inconsistent at best.
-		// Try to pull out what we want and clean it up a bit.
-		for i, v := range date {
-			if foundDate == "true" {
-				foundDate = "done"
-				continue
-			}
-			if foundDate == "false" {
-				if v == "Added:" {
-					workDate := date[i+1]
-					finalDate = workDate[0:10]
-					// Only print today's releases
-					currentTime := time.Now()
-					if memoryDate != currentTime.Format("2006-01-02") {
-						//if memoryDate != "2020-09-17" {
-						memoryTitles = make([]string, 0)
-						//memoryDate = "2020-09-17"
-						memoryDate = currentTime.Format("2006-01-02")
-					}
-					if finalDate != memoryDate {
-						data["sendMessage"] = "false"
-					}
-					if len(workDate) > 10 {
-						endText = workDate[10:] + " "
-					}
-					foundDate = "true"
-				}
-			} else {
-				endText = endText + v + " "
-			}
-		}
-		foundTitle := false
-		for _, v := range memoryTitles {
-			if v == title[0] {
-				foundTitle = true
-				data["sendMessage"] = "false"
-				break
-			}
-		}
-		if !foundTitle {
-			memoryTitles = append(memoryTitles, title[0])
-		}
-		if data["sendMessage"] == "false" {
-			return data, nil
+	logger.Debug("fetched search results", "search", s.Name, "url", url, "duration", time.Since(start), "rows", page.Rows)
+	return page, nil
+}
+
+// query returns the DMs Guild query of a search.
+// The keywords in the config are URL encoded, the client wants them as typed.
+func query(s *search) dmsguild.Query {
+	keywords, err := url.QueryUnescape(s.Keywords)
+	if err != nil {
+		keywords = s.Keywords
+	}
+	return dmsguild.Query{Keywords: keywords, Sort: dmsguild.SortNewest}
+}
+
+// searchURL returns the DMs Guild search results page of a search
+func searchURL(s *search) string {
+	return client.URL(query(s))
+}
+
+// newClient returns the DMs Guild client for a configuration
+func newClient(cfg Config) *dmsguild.Client {
+	return dmsguild.NewClient(
+		dmsguild.WithBaseURL(cfg.Dmsguild.BaseURL),
+		dmsguild.WithHTTPClient(httpClient),
+		dmsguild.WithRateLimiter(hostLimiter(cfg.Dmsguild.BaseURL, cfg.Polling.RequestsPerSecond, cfg.Polling.Burst)),
+	)
+}
+
+// skipReason returns why a product should not be posted for a search,
+// or an empty string if it should be.
+func skipReason(s *search, p dmsguild.Product) string {
+	switch {
+	case !s.matchTitle(p.Title):
+		return "filtered"
+	// Only post today's releases
+	case p.DateAdded != memoryDate:
+		return "old_date"
+	// Skip anything that was already delivered, or is still waiting in the outbox.
+	case box.known(s.Name, p.Title):
+		return "duplicate"
+	}
+	return ""
+}
+
+// priceLine returns a cleaned up price line for the message.
+func priceLine(price string) string {
+	if price == "" {
+		return ""
+	}
+	if salePrice.MatchString(price) {
+		return priceClean(price)
+	}
+	return "**Price**: " + price
+}
+
+// processPage goes through the products on a page of search results
+// and queues the new ones for delivery.
+// Rows that could not be parsed are quarantined, so they can't stop the others from being posted.
+// It returns how many new products were queued.
+func processPage(s *search, page *dmsguild.Page) int {
+	today := time.Now().Format("2006-01-02")
+	if memoryDate != today {
+		memoryDate = today
+		box.newDay(memoryDate)
+	}
+
+	skipped := make([]string, 0, len(page.Malformed))
+	for _, row := range page.Malformed {
+		reason := row.Err.Error()
+		path, qErr := quarantineRow(cfg.Settings.Quarantine, s.Name, row.Index, row.HTML, reason)
+		if qErr != nil {
+			logger.Error("could not quarantine row", "search", s.Name, "row", row.Index, "error", qErr)
+		} else if path != "" {
+			reason = reason + " (saved to " + path + ")"
 		}
-		data["message"] = "**__" + title[0] + "__**\n"
-		data["message"] = data["message"] + "**Date Added**: " + finalDate + "\n"
-		data["message"] = data["message"] + "**Description**:\n"
-		if endText != "" {
-			data["message"] = data["message"] + removeClick(endText) + "\n"
+		skipped = append(skipped, "row "+strconv.Itoa(row.Index)+": "+reason)
+		rowsSkip.Inc(s.Name, "malformed")
+	}
+
+	// The rows that are not products, like headers
+	other := page.Rows - len(page.Products) - len(page.Malformed)
+	rowsParsed.Add(float64(other), s.Name)
+	rowsSkip.Add(float64(other), s.Name, "not_product")
+
+	queued := 0
+	for _, p := range page.Products {
+		rowsParsed.Inc(s.Name)
+		if reason := skipReason(s, p); reason != "" {
+			rowsSkip.Inc(s.Name, reason)
+			continue
 		}
-	} else {
-		return data, nil
+		sendMessage(s, p)
+		queued++
+	}
+
+	logger.Info("processed search results", "search", s.Name, "rows", page.Rows, "new", queued, "skipped", len(skipped))
+	for _, reason := range skipped {
+		logger.Warn("skipped malformed row", "search", s.Name, "reason", reason)
+	}
+	return queued
+}
+
+// sendMessage builds the message and queues it in the outbox for each of the search's notifiers.
+// The actual sending is done by the outbox, so that failed sends are retried.
+func sendMessage(s *search, product dmsguild.Product) {
+	p := newProduct(product)
+	if logger.DebugEnabled() {
+		logger.Debug("parsed product", "search", s.Name, "product_id", p.ID, "title", p.Title, "date_added", p.DateAdded,
+			"price", p.Price, "url", p.Link, "image", p.Image, "description", p.Description)
 	}
-	return data, nil
+	box.enqueue(s, p)
 }
 
-// handlePrice tries to return a cleaned up price line for the message.
-func handlePrice(data map[string]string, line string) map[string]string {
-	match, err := regexp.Match(`\d+\s+\$`, []byte(line))
+// affiliateLink adds the affiliate ID to the end of the query of a product link,
+// after the parameters it already has
+func affiliateLink(link, affiliate string) string {
+	u, err := url.Parse(link)
 	if err != nil {
-		fmt.Println("["+time.Now().String()+"] [ERROR] could not match price pattern: ", err)
-		match = false
+		logger.Warn("could not add the affiliate ID to the product link", "url", link, "error", err)
+		return link
 	}
-	if match {
-		data["price"] = priceClean(line)
-	} else {
-		data["price"] = "**Price**: " + line
+	q := u.Query()
+	q.Del("affiliate_id")
+	query := url.Values{"affiliate_id": {affiliate}}.Encode()
+	if len(q) > 0 {
+		query = q.Encode() + "&" + query
 	}
-	return data
+	u.RawQuery = query
+	return u.String()
 }
 
-// processLines iterates through all the lines for a product
-// to try and build a message from the data.
-func processLines(parts []string) (map[string]string, error) {
-	var err error
-	data := make(map[string]string)
-	data["message"] = ""
-	data["firstLine"] = "false"
-	data["price"] = ""
-	for _, s := range parts {
-		if strings.TrimSpace(s) == "" {
-			continue
-		} else if data["firstLine"] == "false" {
-			data, err = handleTitleLine(data, s)
-			if err != nil {
-				return nil, err
-			}
-			if data["sendMessage"] == "false" {
-				break
-			}
-		} else {
-			line := strings.TrimSpace(s)
-			if strings.HasPrefix(line, "$") || line == "FREE" || line == "Pay What You Want" {
-				data = handlePrice(data, line)
-				continue
-			}
-			if line != "Dungeon Masters Guild" {
-				data["message"] = data["message"] + removeClick(s) + "\n"
-			}
+// newProduct adds the affiliate ID to the link of a product and renders its Discord message
+func newProduct(product dmsguild.Product) Product {
+	description := ""
+	if product.Description != "" {
+		for _, line := range strings.Split(product.Description, "\n") {
+			description = description + disableURL(line) + "\n"
 		}
 	}
-	return data, nil
+
+	link := affiliateLink(product.Link, cfg.Dmsguild.Affiliate)
+	message := "**__" + product.Title + "__**\n"
+	message = message + "**Date Added**: " + product.DateAdded + "\n"
+	message = message + "**Description**:\n"
+	message = message + description
+	message = message + "[*click the link below for more information*]\n"
+	message = message + priceLine(product.Price) + "\n"
+	message = message + "**Link**: " + link
+
+	// The other sinks get the description as it is, disableURL is only for Discord
+	return Product{
+		ID:          product.ID,
+		Title:       product.Title,
+		DateAdded:   product.DateAdded,
+		Description: strings.TrimSpace(product.Description),
+		Price:       product.Price,
+		Link:        link,
+		Image:       product.Image,
+		Message:     message,
+	}
 }
 
-// processRows takes the rows we care about and start to iterate over them.
-// This function manages the message creation and sending.
-func processRows(rows []soup.Root) error {
-	// process the rows
-	for _, row := range rows {
+// searchOutcome is how checking a search went, nil err meaning it worked
+type searchOutcome struct {
+	search *search
+	err    error
+}
 
-		//Grab full text
-		desc := row.FullText()
+// queueResults queues the new products of each of the searches in due, one search after
+// the other, from the results of fetching them, and records how every search went.
+// Searches that were cut off by a shutdown are left out, that says nothing about the search.
+func queueResults(ctx context.Context, due []*search, results []fetchResult) []searchOutcome {
+	queueMu.Lock()
+	defer queueMu.Unlock()
 
-		// Split the full text into lines.
-		parts := strings.Split(desc, "\n")
+	outcomes := make([]searchOutcome, 0, len(due))
+	for i, s := range due {
+		r := results[i]
+		b := breakerFor(s.Name)
+		if r.err != nil && ctx.Err() == context.Canceled {
+			continue
+		}
+		if r.err != nil {
+			observePoll(s.Name, r.start, r.err)
+			health.pollResult(s.Name, r.err)
+			b.failure(s.Name, r.err, s.pollInterval(cfg))
+			logger.Error("could not check search", "search", s.Name, "url", searchURL(s), "attempts", r.attempts, "error", r.err)
+			outcomes = append(outcomes, searchOutcome{search: s, err: r.err})
+			continue
+		}
 
-		// Iterate over the lines.
-		data, err := processLines(parts)
-		if err != nil {
-			return err
+		s.found = processPage(s, r.page)
+		observePoll(s.Name, r.start, nil)
+		health.pollResult(s.Name, nil)
+		b.success(s.Name)
+		logger.Debug("finished search", "search", s.Name, "duration", time.Since(r.start))
+		outcomes = append(outcomes, searchOutcome{search: s})
+	}
+	return outcomes
+}
+
+// updateMessage coordinates all the work of pulling in the search results,
+// parsing and then posting them, for each of the searches in list.
+// Everything stops when ctx is done. Searches that are still being checked by an earlier call are skipped.
+func updateMessage(ctx context.Context, list []*search) error {
+	list = claimSearches(list)
+	defer releaseSearches(list)
+
+	due := make([]*search, 0, len(list))
+	for _, s := range list {
+		if !breakerFor(s.Name).allow(time.Now()) {
+			pollsTotal.Inc(s.Name, "paused")
+			logger.Debug("skipping paused search", "search", s.Name)
+			continue
 		}
+		due = append(due, s)
+	}
 
-		if data["message"] == "" {
+	// Fetch concurrently, but queue the results one search after the other,
+	// so they are always posted in the same order
+	results := fetchAll(ctx, due, cfg.Polling.Workers)
+	failed := make([]string, 0)
+	// The alerts are posted to Discord, so they wait until the results are queued
+	for _, o := range queueResults(ctx, due, results) {
+		if o.err != nil {
+			alerts.failure(searchSubject(o.search), searchURL(o.search), o.err)
+			failed = append(failed, o.search.Name)
 			continue
 		}
+		alerts.success(searchSubject(o.search))
+	}
 
-		// Grab link
-		links := row.FindAll("a")
-		data["link"] = links[0].Attrs()["href"]
+	box.deliver(ctx)
+	if len(failed) > 0 {
+		return &searchesFailed{failed: failed, total: len(list)}
+	}
+	return nil
+}
 
-		// Assemble & send final message
-		err = sendMessage(data)
-		if err != nil {
-			return err
-		}
+// setup creates the shared sinks (the email digest and the feeds) and the searches
+// that post to them. When called again on a reload, the feeds' items are kept,
+// and the releases waiting for the next digest stay in the outbox. Nothing changes if it fails.
+func setup(cfg Config) error {
+	prevDigest, prevFeeds := digest, feeds
+	digest = nil
+	if cfg.Email.Host != "" && cfg.Email.From != "" && len(cfg.Email.Subscribers) > 0 {
+		digest = newEmailDigest(cfg)
 	}
+	if cfg.Feeds.Enabled && feeds == nil {
+		feeds = newFeedStore(cfg.Feeds.Size, cfg.Server.BaseURL)
+	}
+
+	list, err := buildSearches(cfg)
+	if err != nil {
+		digest, feeds = prevDigest, prevFeeds
+		return err
+	}
+	if feeds != nil {
+		feeds.resize(cfg.Feeds.Size)
+	}
+
+	searchesMu.Lock()
+	searches = list
+	searchesMu.Unlock()
 	return nil
 }
 
-// sendMessage finalizes the message and sends it to Discord.
-func sendMessage(data map[string]string) error {
-	data["message"] = data["message"] + "[*click the link below for more information*]\n"
-	data["message"] = data["message"] + data["price"] + "\n"
+// scheduler runs the scheduled checks and the email digest
+type scheduler struct {
+	ctx          context.Context
+	stopDigest   chan bool
+	stopSearches chan bool
+}
 
-	data["message"] = data["message"] + "**Link**: " + data["link"] + "?affiliate_id=" + cfg.Dmsguild.Affiliate
-	//fmt.Println(data["message"])
-	// FIXME: We should not need to check this, but there is a bug that is allowing this to slip through sometimes.
-	if !strings.Contains(data["link"], "browse.php") {
-		_, err := discord.ChannelMessageSend(cfg.Discord.Channel, data["message"])
+// schedule starts the scheduled checks and the email digest, which run under ctx.
+// Call stop on the result to stop them, ctx is only for cancelling the ones that are running.
+func schedule(ctx context.Context, poll func(ctx context.Context, list []*search) error) (*scheduler, error) {
+	sched := &scheduler{ctx: ctx, stopSearches: make(chan bool, 1)}
+	if err := sched.startDigest(); err != nil {
+		return nil, err
+	}
+	go runSearches(ctx, sched.stopSearches, 5*time.Second, poll)
+	return sched, nil
+}
+
+// startDigest schedules the email digest, if there is one.
+// Sending it may take up to settings.cycle_timeout, like a check.
+func (sched *scheduler) startDigest() error {
+	jobs := gocron.NewScheduler()
+	if digest != nil {
+		send, timeout := digest.send, cfg.Settings.CycleTimeout
+		err := jobs.Every(1).Day().At(cfg.Email.SendAt).Do(func() {
+			supervise("digest", func() error {
+				digestMu.Lock()
+				defer digestMu.Unlock()
+				ctx, cancel := context.WithTimeout(sched.ctx, timeout)
+				defer cancel()
+				return send(ctx)
+			})
+		})
 		if err != nil {
-			fmt.Println("["+time.Now().String()+"] [ERROR] could not send Discord message: ", err)
-			return err
+			return fmt.Errorf("could not schedule email digest at %q: %v", cfg.Email.SendAt, err)
 		}
 	}
+	sched.stopDigest = jobs.Start()
 	return nil
 }
 
-// updateMessage coordinates all the work of pulling in the search results,
-// parsing and then posting them.
-func updateMessage(discord *discordgo.Session) error {
-	rows, err := searchRows()
-	if err != nil {
-		return err
+// reload picks up a new configuration. The checks look up the searches and their
+// schedules themselves, so only the email digest, which may have changed, is scheduled again,
+// once a digest that is being sent has finished.
+func (sched *scheduler) reload() error {
+	digestMu.Lock()
+	defer digestMu.Unlock()
+	sched.stopDigest <- true
+	return sched.startDigest()
+}
+
+// stop stops the scheduled checks and the email digest
+func (sched *scheduler) stop() {
+	sched.stopDigest <- true
+	sched.stopSearches <- true
+}
+
+// sinkNames lists the names of a search's notifiers
+func sinkNames(s *search) string {
+	sinks := make([]string, 0, len(s.notifiers))
+	for _, n := range s.notifiers {
+		sinks = append(sinks, n.Name())
 	}
+	return strings.Join(sinks, ", ")
+}
+
+// baseSearches turns the configuration into the list of searches to run, without any notifiers.
+func baseSearches(cfg Config) ([]*search, error) {
+	configs := searchConfigs(cfg)
+
+	result := make([]*search, 0, len(configs))
+	names := make(map[string]bool)
+	for i, sc := range configs {
+		if sc.Name == "" {
+			sc.Name = "search-" + strconv.Itoa(i+1)
+		}
+		if names[sc.Name] {
+			return nil, fmt.Errorf("duplicate search name %q", sc.Name)
+		}
+		names[sc.Name] = true
+		if sc.Keywords == "" {
+			sc.Keywords = cfg.Dmsguild.Keywords
+		}
 
-	err = processRows(rows)
+		s := &search{SearchConfig: sc}
+		if sc.TitleRegex != "" {
+			re, err := regexp.Compile(sc.TitleRegex)
+			if err != nil {
+				return nil, fmt.Errorf("search %q has an invalid title_regex: %v", sc.Name, err)
+			}
+			s.titleRegex = re
+		}
+		var err error
+		s.cron, s.location, s.quiet, err = parseSchedule(sc)
+		if err != nil {
+			return nil, fmt.Errorf("search %q: %v", sc.Name, err)
+		}
+		if s.cron != nil {
+			s.cronGap = s.cron.maxGap(time.Now().In(s.location))
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// buildSearches turns the configuration into the list of searches to run,
+// each with its own set of notifiers.
+func buildSearches(cfg Config) ([]*search, error) {
+	result, err := baseSearches(cfg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	for _, s := range result {
+		sc := s.SearchConfig
+		if sc.Discord.Channel != "" {
+			s.notifiers = append(s.notifiers, &discordNotifier{session: discord, channel: sc.Discord.Channel})
+		}
+		if sc.Slack.WebhookURL != "" {
+			s.notifiers = append(s.notifiers, &slackNotifier{webhookURL: sc.Slack.WebhookURL})
+		}
+		if sc.Matrix.Room != "" {
+			if cfg.Matrix.Homeserver == "" || cfg.Matrix.Token == "" {
+				return nil, fmt.Errorf("search %q posts to Matrix, but matrix.homeserver and matrix.token are not set", sc.Name)
+			}
+			s.notifiers = append(s.notifiers, &matrixNotifier{homeserver: cfg.Matrix.Homeserver, token: cfg.Matrix.Token, room: sc.Matrix.Room})
+		}
+		if sc.Email.Enabled {
+			if digest == nil {
+				return nil, fmt.Errorf("search %q sends an email digest, but email.host, email.from and email.subscribers are not all set", sc.Name)
+			}
+			s.notifiers = append(s.notifiers, &emailNotifier{digest: digest, search: sc.Name})
+		}
+		for _, wh := range sc.Webhooks {
+			if wh.URL == "" {
+				return nil, fmt.Errorf("search %q has a webhook without a url", sc.Name)
+			}
+			s.notifiers = append(s.notifiers, &webhookNotifier{url: wh.URL, secret: wh.Secret, search: sc.Name})
+		}
+		if sc.Mastodon.Enabled {
+			if cfg.Mastodon.Instance == "" || cfg.Mastodon.Token == "" {
+				return nil, fmt.Errorf("search %q posts to Mastodon, but mastodon.instance and mastodon.token are not set", sc.Name)
+			}
+			s.notifiers = append(s.notifiers, &mastodonNotifier{
+				instance:   cfg.Mastodon.Instance,
+				token:      cfg.Mastodon.Token,
+				visibility: cfg.Mastodon.Visibility,
+				hashtags:   cfg.Mastodon.Hashtags,
+				search:     sc.Name,
+			})
+		}
+		if sc.Telegram.ChatID != "" {
+			if cfg.Telegram.Token == "" {
+				return nil, fmt.Errorf("search %q posts to Telegram, but telegram.token is not set", sc.Name)
+			}
+			s.notifiers = append(s.notifiers, &telegramNotifier{apiURL: cfg.Telegram.APIURL, token: cfg.Telegram.Token, chatID: sc.Telegram.ChatID})
+		}
+		if feeds != nil {
+			s.notifiers = append(s.notifiers, &feedNotifier{store: feeds, search: sc.Name})
+		}
+		if len(s.notifiers) == 0 {
+			return nil, fmt.Errorf("search %q does not post anywhere", sc.Name)
+		}
+	}
+	return result, nil
 }
 
 // main is where everything starts.
@@ -337,48 +870,157 @@ func updateMessage(discord *discordgo.Session) error {
 // and then finally setup the ongoinging scheduled checks.
 func main() {
 	var err error
-	args := ProcessArgs(&cfg)
+	args := ProcessArgs()
+	ctx := SetupSignalHandler()
 
 	// read configuration from the file and environment variables
-	if err = cleanenv.ReadConfig(args.ConfigPath, &cfg); err != nil {
-		fmt.Println("["+time.Now().String()+"] [ERROR] Reading configuration: ", err)
+	err = cleanenv.ReadConfig(args.ConfigPath, &cfg)
+	if err != nil && args.Command == "search" && os.IsNotExist(err) {
+		// search doesn't need a config file
+		err = cleanenv.ReadEnv(&cfg)
+	}
+	if err != nil {
+		logger.Error("could not read configuration", "path", args.ConfigPath, "error", err)
 		os.Exit(2)
 	}
+	secretProblems := readSecretFiles(&cfg)
+	client = newClient(cfg)
 
-	fmt.Printf("\n[" + time.Now().String() + "] Initilizing application...\n\n")
-	fmt.Println("Keywords for search   : ", cfg.Dmsguild.Keywords)
-	fmt.Println("Title filter (if any) : ", cfg.Dmsguild.TitleFilter)
-	fmt.Println("Affiliate code        : ", cfg.Dmsguild.Affiliate)
-	fmt.Println("Minutes between checks: ", cfg.Settings.Minutes)
-	fmt.Printf("\n")
+	if err = logger.Configure(cfg.Settings.LogLevel, cfg.Settings.LogFormat); err != nil && args.Command != "validate" {
+		logger.Error("could not configure logging", "error", err)
+		os.Exit(2)
+	}
 
-	discord, err = discordgo.New("Bot " + cfg.Discord.Token)
+	switch {
+	case args.Command == "search":
+		os.Exit(searchCommand(ctx, args, os.Stdout))
+	case args.Command == "state":
+		os.Exit(stateCommand(args))
+	case args.DryRun:
+		os.Exit(dryRun(ctx, args.DryRunOutput))
+	}
+
+	problems := append(secretProblems, validateConfig(cfg, false)...)
+	if args.Command == "validate" {
+		os.Exit(checkConfig(args, problems))
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			logger.Error("invalid configuration", "path", args.ConfigPath, "error", p)
+		}
+		os.Exit(2)
+	}
+
+	box, err = loadOutbox(cfg.Settings.StateFile, cfg.Settings.MaxAttempts)
 	if err != nil {
-		fmt.Println("["+time.Now().String()+"] [ERROR] could not create Discord session: ", err)
-		os.Exit(1)
+		logger.Error("could not read state file", "path", cfg.Settings.StateFile, "error", err)
+		os.Exit(2)
+	}
+	if args.DeadLetters {
+		box.printDead()
+		os.Exit(0)
 	}
+	box.newDay(memoryDate)
 
-	min, err := strconv.ParseInt(cfg.Settings.Minutes, 10, 64)
+	logger.Info("initializing application", "affiliate", cfg.Dmsguild.Affiliate, "minutes", cfg.Settings.Minutes)
+
+	discord, err = discordgo.New("Bot " + cfg.Discord.Token)
 	if err != nil {
-		fmt.Println("["+time.Now().String()+"] [ERROR] could not convert minute argument to integer: ", err)
+		logger.Error("could not create Discord session", "error", err)
 		os.Exit(1)
 	}
 
+	if err = setup(cfg); err != nil {
+		logger.Error("could not set up searches", "error", err)
+		os.Exit(2)
+	}
+	logSearches()
+
+	health.configure(cfg.Health.StaleFactor)
+	alerts.configure(cfg.Alerts.Channel, cfg.Alerts.Users, cfg.Alerts.After, cfg.Alerts.MinInterval)
+
+	// Check the token up front, so /readyz reports a bad token before the first post
+	if cfg.Discord.Token != "" {
+		_, err = discord.User("@me")
+		health.discordResult(err)
+		if err != nil {
+			logger.Warn("could not verify the Discord token", "error", err)
+		}
+	}
+
+	var srv *http.Server
+	if cfg.Server.Listen != "" && args.Command == "run" {
+		srv = startServer(cfg)
+	}
+
+	// The checks and deliveries run under work rather than ctx, so a signal doesn't cut them off.
+	// shutdown only cancels work once they have had settings.shutdown_timeout to finish.
+	work, stopWork := context.WithCancel(context.Background())
+
+	// poll checks the searches in list, unless we are shutting down.
+	// Every check gets settings.cycle_timeout to finish.
+	poll := func(work context.Context, list []*search) error {
+		cycleMu.RLock()
+		defer cycleMu.RUnlock()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		work, cancel := context.WithTimeout(work, cfg.Settings.CycleTimeout)
+		defer cancel()
+		return updateMessage(work, list)
+	}
+
+	if args.Command == "once" {
+		// Give the check settings.shutdown_timeout to finish after a signal, like shutdown does
+		go func() {
+			<-ctx.Done()
+			time.Sleep(cfg.Settings.ShutdownTimeout)
+			stopWork()
+		}()
+		os.Exit(runOnce(work, poll))
+	}
+
 	//Run the first time, before the time starts
-	err = updateMessage(discord)
-	if err != nil {
-		fmt.Println("["+time.Now().String()+"] [ERROR] could not perform initial check: ", err)
-		os.Exit(1)
+	// A search that fails is retried on schedule, so this is not fatal
+	err = supervise("check", func() error { return poll(work, searches) })
+	if err != nil && ctx.Err() == nil {
+		logger.Warn("initial check failed, will retry on schedule")
 	}
 
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
+	// Retry failed deliveries in between searches, until we are shutting down
+	go box.run(work, ctx.Done(), 30*time.Second)
 
-	err = gocron.Every(uint64(min)).Minute().Do(updateMessage, discord)
+	sched, err := schedule(work, poll)
 	if err != nil {
-		fmt.Println("["+time.Now().String()+"] [ERROR] could not schedule search: ", err)
+		logger.Error("could not start the scheduler", "error", err)
 		os.Exit(1)
 	}
-	<-gocron.Start()
-	wg.Wait()
+
+	reloads := watchConfig(ctx, args.ConfigPath, cfg.Settings.ReloadInterval)
+	for {
+		select {
+		case <-ctx.Done():
+			shutdown(sched, srv, cfg.Settings.ShutdownTimeout, stopWork)
+			return
+		case reason := <-reloads:
+			logger.Info("reloading configuration", "path", args.ConfigPath, "reason", reason)
+			if err = reloadConfig(args.ConfigPath); err != nil {
+				logger.Error("could not reload configuration, keeping the old one", "path", args.ConfigPath, "error", err)
+				continue
+			}
+			if err = sched.reload(); err != nil {
+				logger.Error("could not reschedule the email digest", "error", err)
+				os.Exit(1)
+			}
+			logSearches()
+		}
+	}
+}
+
+// logSearches logs the configured searches
+func logSearches() {
+	for _, s := range searches {
+		logger.Info("configured search", "search", s.Name, "keywords", s.Keywords, "title_filter", s.TitleFilter,
+			"title_regex", s.TitleRegex, "sinks", sinkNames(s))
+	}
 }
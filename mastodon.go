@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// mastodonLimit is the default character limit of a status
+const mastodonLimit = 500
+
+// mastodonURLLength is how many characters Mastodon counts for any URL, however long it is
+const mastodonURLLength = 23
+
+// mastodonHashtagLimit is the most characters the hashtags may take up,
+// so there is always room left for the title
+const mastodonHashtagLimit = 250
+
+// mastodonNotifier posts products as statuses through the Mastodon REST API.
+type mastodonNotifier struct {
+	instance   string
+	token      string
+	visibility string
+	hashtags   []string
+	search     string
+}
+
+// Name returns the notifier description
+func (n *mastodonNotifier) Name() string {
+	return "Mastodon " + n.instance
+}
+
+// Notify uploads the cover image, if there is one, and posts the status
+func (n *mastodonNotifier) Notify(ctx context.Context, p Product) error {
+	form := url.Values{}
+	form.Set("status", mastodonStatus(p, n.hashtags))
+	form.Set("visibility", n.visibility)
+
+	if p.Image != "" {
+		// A missing cover is not worth losing the post over.
+		id, err := n.uploadImage(ctx, p)
+		if err != nil {
+			logger.Warn("could not attach cover image to Mastodon status", "search", n.search, "product_id", p.ID, "url", p.Image, "error", err)
+		} else {
+			form.Set("media_ids[]", id)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.endpoint("/api/v1/statuses"), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Lets Mastodon drop duplicates if the same product is posted twice
+	req.Header.Set("Idempotency-Key", n.search+"/"+feedItemID(p))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	reply, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mastodon returned %s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
+
+// uploadImage downloads the cover image and uploads it as a media attachment, returning its ID
+func (n *mastodonNotifier) uploadImage(ctx context.Context, p Product) (string, error) {
+	imgReq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Image, nil)
+	if err != nil {
+		return "", err
+	}
+	img, err := httpClient.Do(imgReq)
+	if err != nil {
+		return "", err
+	}
+	defer img.Body.Close()
+	if img.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cover image returned %s", img.Status)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	name := path.Base(img.Request.URL.Path)
+	if name == "" || name == "/" || name == "." {
+		name = "cover.jpg"
+	}
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(fw, img.Body); err != nil {
+		return "", err
+	}
+	if err = mw.WriteField("description", "Cover of "+p.Title); err != nil {
+		return "", err
+	}
+	if err = mw.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.endpoint("/api/v2/media"), &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.token)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	reply, _ := ioutil.ReadAll(resp.Body)
+	// 202 means the image is still being processed, but the ID can already be used.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return "", fmt.Errorf("mastodon media upload returned %s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	var media struct {
+		ID string `json:"id"`
+	}
+	if err = json.Unmarshal(reply, &media); err != nil {
+		return "", err
+	}
+	return media.ID, nil
+}
+
+// endpoint returns the full URL of an API path on the instance
+func (n *mastodonNotifier) endpoint(apiPath string) string {
+	return strings.TrimRight(n.instance, "/") + apiPath
+}
+
+// mastodonTags renders the hashtags, adding the # where it is missing
+func mastodonTags(hashtags []string) string {
+	tags := make([]string, 0, len(hashtags))
+	for _, t := range hashtags {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !strings.HasPrefix(t, "#") {
+			t = "#" + t
+		}
+		tags = append(tags, t)
+	}
+	return strings.Join(tags, " ")
+}
+
+// mastodonStatus renders the status text, shortening the description (and if need be the title)
+// so the whole status fits in the character limit. Hashtags that don't leave room for the title are left out.
+func mastodonStatus(p Product, hashtags []string) string {
+	tags := mastodonTags(hashtags)
+	if utf8.RuneCountInString(tags) > mastodonHashtagLimit {
+		tags = ""
+	}
+
+	head := p.Title + "\n" + p.PriceText() + " · Added " + p.DateAdded
+	tail := "\n\n" + p.Link
+	if tags != "" {
+		tail = tail + "\n\n" + tags
+	}
+	// The link is counted as a fixed length, no matter how long it really is.
+	tailLength := utf8.RuneCountInString(tail) - utf8.RuneCountInString(p.Link) + mastodonURLLength
+
+	if utf8.RuneCountInString(head)+tailLength > mastodonLimit {
+		head = truncate(head, mastodonLimit-tailLength)
+		return head + tail
+	}
+
+	room := mastodonLimit - utf8.RuneCountInString(head) - tailLength - 2
+	if p.Description != "" && room > 20 {
+		head = head + "\n\n" + truncate(p.Description, room)
+	}
+	return head + tail
+}
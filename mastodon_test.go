@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// mastodonLength counts the characters of a status the way Mastodon does, with link as a fixed length
+func mastodonLength(status, link string) int {
+	return utf8.RuneCountInString(strings.Replace(status, link, strings.Repeat("x", mastodonURLLength), -1))
+}
+
+func TestMastodonNotify(t *testing.T) {
+	var media, status *http.Request
+	var upload []byte
+	var description string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/images/cover.png":
+			w.Write([]byte("PNG data"))
+		case "/api/v2/media":
+			media = r
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				t.Errorf("no file in the upload: %v", err)
+				http.Error(w, "no file", http.StatusUnprocessableEntity)
+				return
+			}
+			upload, _ = ioutil.ReadAll(file)
+			description = r.FormValue("description")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"id":"42"}`))
+		case "/api/v1/statuses":
+			status = r
+			r.ParseForm()
+			w.Write([]byte(`{"id":"1"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := Product{
+		ID:          "123",
+		Title:       "Tomb of Horrors",
+		Description: strings.Repeat("word ", 200),
+		DateAdded:   "2026-10-18",
+		Price:       "$4.99 $2.99",
+		Link:        "https://example.com/product/123/" + strings.Repeat("long-", 20),
+		Image:       srv.URL + "/images/cover.png",
+	}
+	n := &mastodonNotifier{instance: srv.URL + "/", token: "t0ken", visibility: "unlisted", hashtags: []string{"dnd", "#ttrpg", " "}, search: "fg"}
+	if err := n.Notify(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	if media == nil || status == nil {
+		t.Fatal("expected a media upload and a status")
+	}
+	if string(upload) != "PNG data" || description != "Cover of Tomb of Horrors" {
+		t.Errorf("uploaded %q described as %q", upload, description)
+	}
+	for _, r := range []*http.Request{media, status} {
+		if got := r.Header.Get("Authorization"); got != "Bearer t0ken" {
+			t.Errorf("%s: authorization %q", r.URL.Path, got)
+		}
+	}
+	if got := status.Header.Get("Idempotency-Key"); got != "fg/"+feedItemID(p) {
+		t.Errorf("idempotency key %q", got)
+	}
+	if got := status.PostForm.Get("visibility"); got != "unlisted" {
+		t.Errorf("visibility %q", got)
+	}
+	if got := status.PostForm.Get("media_ids[]"); got != "42" {
+		t.Errorf("media IDs %q", got)
+	}
+
+	text := status.PostForm.Get("status")
+	if !strings.HasPrefix(text, "Tomb of Horrors\n$2.99 (normally $4.99) · Added 2026-10-18\n\nword word") {
+		t.Errorf("status %q", text)
+	}
+	if !strings.HasSuffix(text, "…\n\n"+p.Link+"\n\n#dnd #ttrpg") {
+		t.Errorf("status doesn't end with the link and hashtags: %q", text)
+	}
+	if got := mastodonLength(text, p.Link); got != mastodonLimit {
+		t.Errorf("status is %d characters, want the description cut to fit %d", got, mastodonLimit)
+	}
+}
+
+func TestMastodonNotifyWithoutCover(t *testing.T) {
+	var mediaIDs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/statuses":
+			r.ParseForm()
+			mediaIDs = r.PostForm["media_ids[]"]
+			w.Write([]byte(`{"id":"1"}`))
+		default:
+			// The cover image is missing
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	n := &mastodonNotifier{instance: srv.URL, token: "t0ken", visibility: "public"}
+	if err := n.Notify(context.Background(), Product{Title: "Title", Link: "https://example.com", Image: srv.URL + "/missing.png"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(mediaIDs) != 0 {
+		t.Errorf("expected no media, got %v", mediaIDs)
+	}
+}
+
+func TestMastodonStatusLimits(t *testing.T) {
+	link := "https://example.com/product/1"
+	tests := []struct {
+		name     string
+		p        Product
+		hashtags []string
+		tags     bool
+	}{
+		{"long title", Product{Title: strings.Repeat("T", 1000), Link: link}, []string{"dnd"}, true},
+		{"long description", Product{Title: "Title", Description: strings.Repeat("d", 1000), Link: link}, []string{"dnd"}, true},
+		// Hashtags longer than the status used to make the title limit negative and panic
+		{"too many hashtags", Product{Title: "Title", Link: link}, []string{strings.Repeat("h", 600)}, false},
+		{"too many hashtags and a long title", Product{Title: strings.Repeat("T", 1000), Link: link}, []string{strings.Repeat("h", 600)}, false},
+	}
+	for _, tt := range tests {
+		status := mastodonStatus(tt.p, tt.hashtags)
+		if got := mastodonLength(status, link); got > mastodonLimit {
+			t.Errorf("%s: status is %d characters", tt.name, got)
+		}
+		if !strings.HasPrefix(status, "T") {
+			t.Errorf("%s: status doesn't start with the title: %q", tt.name, status)
+		}
+		if strings.Contains(status, "#") != tt.tags {
+			t.Errorf("%s: status %q, hashtags expected: %v", tt.name, status, tt.tags)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// matrixTxnCounter keeps transaction IDs unique within a single run,
+// for messages that are not sent through the outbox.
+var matrixTxnCounter uint64
+
+// matrixNotifier posts products to a Matrix room through the client-server API.
+type matrixNotifier struct {
+	homeserver string
+	token      string
+	room       string
+}
+
+// matrixMessage is an m.room.message event with an HTML formatted body
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+// Name returns the notifier description
+func (n *matrixNotifier) Name() string {
+	return "Matrix room " + n.room
+}
+
+// Notify sends the product to the room as an HTML message with a plain text fallback
+func (n *matrixNotifier) Notify(ctx context.Context, p Product) error {
+	body, err := json.Marshal(matrixMessage{
+		MsgType:       "m.text",
+		Body:          matrixPlain(p),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: matrixHTML(p),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The transaction ID is the outbox item ID, which stays the same for every attempt,
+	// so the homeserver drops the duplicate if an earlier attempt did get through.
+	txnID, ok := deliveryID(ctx)
+	if !ok {
+		txnID = strconv.FormatInt(time.Now().UnixNano(), 10) + "." + strconv.FormatUint(atomic.AddUint64(&matrixTxnCounter, 1), 10)
+	}
+	endpoint := strings.TrimRight(n.homeserver, "/") + "/_matrix/client/r0/rooms/" +
+		url.PathEscape(n.room) + "/send/m.room.message/" + url.PathEscape(txnID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	reply, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("matrix homeserver returned %s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
+
+// matrixPlain renders the plain text fallback body
+func matrixPlain(p Product) string {
+	text := p.Title + "\n"
+	text = text + "Date Added: " + p.DateAdded + "\n"
+	if p.Description != "" {
+		text = text + p.Description + "\n"
+	}
+	text = text + "Price: " + p.PriceText() + "\n"
+	return text + p.Link
+}
+
+// matrixHTML renders the formatted body, using the subset of HTML that Matrix clients support
+func matrixHTML(p Product) string {
+	text := `<h4><a href="` + html.EscapeString(p.Link) + `">` + html.EscapeString(p.Title) + "</a></h4>\n"
+	text = text + "<p><strong>Date Added</strong>: " + html.EscapeString(p.DateAdded) + "</p>\n"
+	if p.Description != "" {
+		text = text + "<p>" + strings.Replace(html.EscapeString(p.Description), "\n", "<br>", -1) + "</p>\n"
+	}
+	return text + "<p><strong>Price</strong>: " + html.EscapeString(p.PriceText()) + "</p>"
+}
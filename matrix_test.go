@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMatrixNotify(t *testing.T) {
+	var requests []*http.Request
+	var bodies [][]byte
+	status := http.StatusBadGateway
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+		w.Write([]byte(`{"event_id":"$1"}`))
+	}))
+	defer srv.Close()
+
+	n := &matrixNotifier{homeserver: srv.URL + "/", token: "syt_t0ken", room: "!room:example.org"}
+	p := Product{ID: "123", Title: "A <Title>", DateAdded: "2026-10-18", Price: "$4.99", Link: "https://example.com/product/123"}
+	ctx := withDelivery(context.Background(), "item-1")
+
+	if err := n.Notify(ctx, p); err == nil {
+		t.Fatal("expected an error for a 502")
+	}
+	status = http.StatusOK
+	if err := n.Notify(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected two attempts, got %d", len(requests))
+	}
+
+	// The retry has the same transaction ID, so the homeserver can drop it if the first one got through
+	for i, r := range requests {
+		if r.Method != http.MethodPut {
+			t.Errorf("request %d: method %s", i, r.Method)
+		}
+		if got, want := r.URL.EscapedPath(), "/_matrix/client/r0/rooms/%21room:example.org/send/m.room.message/item-1"; got != want {
+			t.Errorf("request %d: path %q, want %q", i, got, want)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer syt_t0ken" {
+			t.Errorf("request %d: authorization %q", i, got)
+		}
+	}
+
+	var msg matrixMessage
+	if err := json.Unmarshal(bodies[1], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.MsgType != "m.text" || msg.Format != "org.matrix.custom.html" {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+	if !strings.Contains(msg.FormattedBody, "A &lt;Title&gt;") || !strings.Contains(msg.Body, "A <Title>") {
+		t.Errorf("title not escaped as expected: %+v", msg)
+	}
+}
+
+func TestMatrixNotifyWithoutOutbox(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	n := &matrixNotifier{homeserver: srv.URL, token: "syt_t0ken", room: "!room:example.org"}
+	for i := 0; i < 2; i++ {
+		if err := n.Notify(context.Background(), Product{Title: "Sharn"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(paths) != 2 || paths[0] == paths[1] {
+		t.Errorf("messages sent outside the outbox need their own transaction IDs, got %v", paths)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricFamily is anything that can write itself in the Prometheus text exposition format.
+type metricFamily interface {
+	write(buf *bytes.Buffer)
+}
+
+// metricVec holds the values of a counter or gauge for every combination of label values.
+type metricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// histogramVec holds a histogram for every combination of label values.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts map[string][]uint64
+	sums   map[string]float64
+	totals map[string]uint64
+}
+
+// gaugeFunc is a gauge without labels whose value is read when it is scraped.
+type gaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// labelEscaper escapes label values as required by the text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricRegistry is the list of metrics served on /metrics, in the order they were registered.
+var metricRegistry []metricFamily
+
+// The application's metrics.
+var (
+	pollDuration = newHistogramVec("dmsguild_poll_duration_seconds", "Time taken to fetch and parse the results of a search.",
+		[]float64{0.5, 1, 2.5, 5, 10, 30, 60, 120}, "search")
+	pollsTotal = newMetricVec("dmsguild_polls_total", "Searches run, by result.", "counter", "search", "result")
+	lastPoll   = newMetricVec("dmsguild_last_successful_poll_timestamp_seconds", "Unix time of the last successful poll of a search.", "gauge", "search")
+	rowsParsed = newMetricVec("dmsguild_rows_parsed_total", "Search result rows parsed.", "counter", "search")
+	rowsSkip   = newMetricVec("dmsguild_rows_skipped_total", "Search result rows that were not posted, by reason.", "counter", "search", "reason")
+	sentTotal  = newMetricVec("dmsguild_messages_sent_total", "Products delivered, by sink.", "counter", "search", "sink")
+	failTotal  = newMetricVec("dmsguild_messages_failed_total", "Failed delivery attempts, by sink.", "counter", "search", "sink")
+
+	pollIntervalSeconds = newMetricVec("dmsguild_poll_interval_seconds", "Current time between polls of a search, without jitter.", "gauge", "search")
+	breakerOpen         = newMetricVec("dmsguild_search_paused", "1 while a search is paused after repeated failures.", "gauge", "search")
+	fetchErrors         = newMetricVec("dmsguild_fetch_errors_total", "Failed attempts at fetching a search, including the ones that were retried.", "counter", "search")
+	jobErrors           = newMetricVec("dmsguild_job_errors_total", "Scheduled jobs that failed, by job.", "counter", "job")
+	alertsTotal         = newMetricVec("dmsguild_alerts_total", "Admin alerts, by result.", "counter", "result")
+)
+
+func init() {
+	newGaugeFunc("dmsguild_dedup_state_size", "Products recorded as delivered today.", func() float64 {
+		if box == nil {
+			return 0
+		}
+		delivered, _, _ := box.sizes()
+		return float64(delivered)
+	})
+	newGaugeFunc("dmsguild_outbox_pending", "Deliveries waiting in the outbox.", func() float64 {
+		if box == nil {
+			return 0
+		}
+		_, pending, _ := box.sizes()
+		return float64(pending)
+	})
+	newGaugeFunc("dmsguild_outbox_dead", "Deliveries that were given up on.", func() float64 {
+		if box == nil {
+			return 0
+		}
+		_, _, dead := box.sizes()
+		return float64(dead)
+	})
+}
+
+// newMetricVec registers a counter or gauge
+func newMetricVec(name, help, kind string, labels ...string) *metricVec {
+	m := &metricVec{name: name, help: help, kind: kind, labels: labels, values: make(map[string]float64)}
+	metricRegistry = append(metricRegistry, m)
+	return m
+}
+
+// newHistogramVec registers a histogram with the given upper bounds
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]uint64),
+	}
+	metricRegistry = append(metricRegistry, h)
+	return h
+}
+
+// newGaugeFunc registers a gauge that is computed on every scrape
+func newGaugeFunc(name, help string, value func() float64) {
+	metricRegistry = append(metricRegistry, &gaugeFunc{name: name, help: help, value: value})
+}
+
+// Add increases the value for the label values by v
+func (m *metricVec) Add(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[strings.Join(labelValues, "\xff")] += v
+}
+
+// Inc increases the value for the label values by one
+func (m *metricVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+// Set replaces the value for the label values
+func (m *metricVec) Set(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[strings.Join(labelValues, "\xff")] = v
+}
+
+// Observe records a single value in the histogram for the label values
+func (h *histogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[key] = counts
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			counts[i]++
+		}
+	}
+	h.sums[key] += v
+	h.totals[key]++
+}
+
+// write renders the counter or gauge
+func (m *metricVec) write(buf *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	writeMetricHeader(buf, m.name, m.help, m.kind)
+	for _, key := range sortedKeys(m.values) {
+		buf.WriteString(m.name + metricLabels(m.labels, key, "", "") + " " + formatMetricValue(m.values[key]) + "\n")
+	}
+}
+
+// write renders the histogram buckets, sum and count
+func (h *histogramVec) write(buf *bytes.Buffer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeMetricHeader(buf, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.counts))
+	for key := range h.counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for i, upper := range h.buckets {
+			buf.WriteString(h.name + "_bucket" + metricLabels(h.labels, key, "le", formatMetricValue(upper)) + " " + strconv.FormatUint(h.counts[key][i], 10) + "\n")
+		}
+		buf.WriteString(h.name + "_bucket" + metricLabels(h.labels, key, "le", "+Inf") + " " + strconv.FormatUint(h.totals[key], 10) + "\n")
+		buf.WriteString(h.name + "_sum" + metricLabels(h.labels, key, "", "") + " " + formatMetricValue(h.sums[key]) + "\n")
+		buf.WriteString(h.name + "_count" + metricLabels(h.labels, key, "", "") + " " + strconv.FormatUint(h.totals[key], 10) + "\n")
+	}
+}
+
+// write renders the gauge with its current value
+func (g *gaugeFunc) write(buf *bytes.Buffer) {
+	writeMetricHeader(buf, g.name, g.help, "gauge")
+	buf.WriteString(g.name + " " + formatMetricValue(g.value()) + "\n")
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of a metric
+func writeMetricHeader(buf *bytes.Buffer, name, help, kind string) {
+	buf.WriteString("# HELP " + name + " " + help + "\n")
+	buf.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// metricLabels renders the label set for a key, with an optional extra label (used for "le")
+func metricLabels(names []string, key, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			if i < len(names) {
+				pairs = append(pairs, names[i]+"=\""+labelEscaper.Replace(value)+"\"")
+			}
+		}
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+"=\""+labelEscaper.Replace(extraValue)+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatMetricValue formats a sample value the way Prometheus expects it
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of a map in order, so the output is stable
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sinkKind is the kind of a notifier, used as the sink label.
+// The notifier names can't be used as they may contain URLs with secrets in them.
+func sinkKind(n Notifier) string {
+	switch n.(type) {
+	case *discordNotifier:
+		return "discord"
+	case *slackNotifier:
+		return "slack"
+	case *matrixNotifier:
+		return "matrix"
+	case *emailNotifier:
+		return "email"
+	case *webhookNotifier:
+		return "webhook"
+	case *mastodonNotifier:
+		return "mastodon"
+	case *telegramNotifier:
+		return "telegram"
+	case *feedNotifier:
+		return "feed"
+	case nil:
+		return "unknown"
+	}
+	return fmt.Sprintf("%T", n)
+}
+
+// observePoll records the outcome of polling a search
+func observePoll(searchName string, start time.Time, err error) {
+	pollDuration.Observe(time.Since(start).Seconds(), searchName)
+	if err != nil {
+		pollsTotal.Inc(searchName, "error")
+		return
+	}
+	pollsTotal.Inc(searchName, "success")
+	lastPoll.Set(float64(time.Now().Unix()), searchName)
+}
+
+// serveMetrics writes every registered metric in the Prometheus text exposition format
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	for _, m := range metricRegistry {
+		m.write(&buf)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
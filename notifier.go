@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// httpClient is shared by all of the notifiers that talk HTTP.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// salePrice matches a price line that has both a normal and a sale price.
+var salePrice = regexp.MustCompile(`\d+\s+\$`)
+
+// Product is a single parsed DMs Guild release, ready to be sent somewhere.
+type Product struct {
+	// ID is the DMs Guild product ID, if it could be found in the link
+	ID          string `json:"id,omitempty"`
+	Title       string `json:"title"`
+	DateAdded   string `json:"date_added"`
+	Description string `json:"description"`
+	// Price is the raw price text from DMs Guild (e.g. "$4.99 $2.99" when on sale)
+	Price string `json:"price"`
+	// Link already includes the affiliate ID
+	Link  string `json:"link"`
+	Image string `json:"image,omitempty"`
+	// Message is the fully rendered Discord message
+	Message string `json:"message"`
+}
+
+// Notifier is implemented by every place we can post a product to.
+type Notifier interface {
+	// Name is a short, human readable description used in log lines.
+	Name() string
+	// Notify delivers a single product, giving up when ctx is done.
+	Notify(ctx context.Context, p Product) error
+}
+
+// discordNotifier posts products to a Discord channel.
+type discordNotifier struct {
+	session *discordgo.Session
+	channel string
+}
+
+// Name returns the notifier description
+func (d *discordNotifier) Name() string {
+	return "Discord channel " + d.channel
+}
+
+// Notify sends the pre-rendered message to the Discord channel
+func (d *discordNotifier) Notify(ctx context.Context, p Product) error {
+	// discordgo can't be cancelled, so at least don't start
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := d.session.ChannelMessageSend(d.channel, p.Message)
+	health.discordResult(err)
+	return err
+}
+
+// PriceText returns the price as plain text, for notifiers that don't use Discord markdown.
+func (p Product) PriceText() string {
+	fields := strings.Fields(p.Price)
+	if len(fields) == 2 && salePrice.MatchString(p.Price) {
+		return fields[1] + " (normally " + fields[0] + ")"
+	}
+	return strings.TrimSpace(p.Price)
+}
+
+// truncate shortens s to at most max characters, adding an ellipsis if anything was cut.
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	if max < 1 {
+		return ""
+	}
+	return strings.TrimSpace(string(r[:max-1])) + "…"
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Delivery retry settings. The delay doubles after every failed attempt, up to outboxMaxBackoff.
+const (
+	outboxFirstBackoff = 30 * time.Second
+	outboxMaxBackoff   = time.Hour
+	// outboxDeadLimit is how many dead-lettered items we keep around for inspection
+	outboxDeadLimit = 200
+	// activityDecay is what the activity counts are multiplied by every day
+	activityDecay = 0.9
+	// activityMinimum is how many products must have been found before busy hours are worked out
+	activityMinimum = 10
+)
+
+// outboxItem is a single product waiting to be delivered to a single notifier.
+type outboxItem struct {
+	ID          string    `json:"id"`
+	Search      string    `json:"search"`
+	Sink        string    `json:"sink"`
+	Product     Product   `json:"product"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// seenProduct is a product that has been delivered to every notifier of a search.
+type seenProduct struct {
+	ID    string `json:"id,omitempty"`
+	Title string `json:"title"`
+}
+
+// outbox is the durable queue of products waiting to be delivered,
+// along with the record of what has already been delivered today.
+// It is saved to the state file after every change, so nothing is lost on a restart.
+type outbox struct {
+	path        string
+	maxAttempts int
+	readOnly    bool
+
+	mu        sync.Mutex
+	Date      string                   `json:"date"`
+	Delivered map[string][]seenProduct `json:"delivered"`
+	Pending   []*outboxItem            `json:"pending"`
+	Dead      []*outboxItem            `json:"dead"`
+	// Activity counts the new products found per search in each hour of the day, for adaptive polling.
+	// DMs Guild only shows the date a product was added, so the hour is when we found it.
+	Activity map[string][]float64 `json:"activity,omitempty"`
+
+	// deliverMu makes sure only one delivery run happens at a time
+	deliverMu sync.Mutex
+	counter   uint64
+}
+
+// loadOutbox reads the outbox from the state file. A missing file is an empty outbox.
+func loadOutbox(path string, maxAttempts int) (*outbox, error) {
+	box := &outbox{
+		path:        path,
+		maxAttempts: maxAttempts,
+		Delivered:   make(map[string][]seenProduct),
+	}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return box, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(raw, box); err != nil {
+		return nil, fmt.Errorf("could not parse state file %s: %v", path, err)
+	}
+	if box.Delivered == nil {
+		box.Delivered = make(map[string][]seenProduct)
+	}
+	return box, nil
+}
+
+// save writes the outbox to the state file. The caller must hold mu.
+// The file is replaced atomically, so a crash can't leave a half written file behind.
+func (b *outbox) save() error {
+	if b.readOnly {
+		return nil
+	}
+	raw, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(b.path), filepath.Base(b.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), b.path)
+}
+
+// detach turns the outbox into an in memory copy for dry runs. It is never saved,
+// and whatever is still queued (or dead) counts as delivered, so it is not posted again.
+func (b *outbox) detach() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.readOnly = true
+	for _, items := range [][]*outboxItem{b.Pending, b.Dead} {
+		for _, item := range items {
+			b.Delivered[item.Search] = append(b.Delivered[item.Search], seenProduct{ID: item.Product.ID, Title: item.Product.Title})
+		}
+	}
+	b.Pending = nil
+	b.Dead = nil
+}
+
+// saveOrLog saves the outbox, logging instead of returning any error. The caller must hold mu.
+// The in memory outbox is still correct if this fails, we just risk losing it on a restart.
+func (b *outbox) saveOrLog() {
+	err := b.save()
+	if err != nil {
+		logger.Error("could not save state file", "path", b.path, "error", err)
+	}
+}
+
+// newDay forgets which products were delivered when the date changes,
+// since we only ever post the current day's releases.
+func (b *outbox) newDay(date string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.Date == date {
+		return
+	}
+	b.Date = date
+	b.Delivered = make(map[string][]seenProduct)
+	// Let old activity fade, so the busy hours follow changes in when products are released
+	for _, hours := range b.Activity {
+		for i := range hours {
+			hours[i] *= activityDecay
+		}
+	}
+	b.saveOrLog()
+}
+
+// busyHour reports whether new products for the search are usually found in this hour of the day:
+// at least twice as often as on average, once enough products have been found to tell.
+func (b *outbox) busyHour(search string, hour int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hours := b.Activity[search]
+	if len(hours) != 24 {
+		return false
+	}
+	var total float64
+	for _, n := range hours {
+		total += n
+	}
+	return total >= activityMinimum && hours[hour] >= 2*total/24
+}
+
+// known reports if a product has already been delivered for the search, or is queued (or dead) in the outbox.
+func (b *outbox) known(search, title string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, seen := range b.Delivered[search] {
+		if seen.Title == title {
+			return true
+		}
+	}
+	for _, items := range [][]*outboxItem{b.Pending, b.Dead} {
+		for _, item := range items {
+			if item.Search == search && item.Product.Title == title {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enqueue queues the product for every notifier of the search and saves the outbox.
+func (b *outbox) enqueue(s *search, p Product) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.Activity == nil {
+		b.Activity = make(map[string][]float64)
+	}
+	if len(b.Activity[s.Name]) != 24 {
+		b.Activity[s.Name] = make([]float64, 24)
+	}
+	b.Activity[s.Name][now.In(s.tz()).Hour()]++
+	for _, n := range s.notifiers {
+		b.counter++
+		b.Pending = append(b.Pending, &outboxItem{
+			ID:          strconv.FormatInt(now.UnixNano(), 36) + "-" + strconv.FormatUint(b.counter, 36),
+			Search:      s.Name,
+			Sink:        n.Name(),
+			Product:     p,
+			Created:     now,
+			NextAttempt: now,
+		})
+	}
+	b.saveOrLog()
+}
+
+// deliver tries to send every item that is due. Items are sent in the order they were queued,
+// and once a notifier fails we stop sending to it until the next run, to keep the order intact.
+// When ctx is done the rest is left for the next run, without counting it as a failed attempt.
+func (b *outbox) deliver(ctx context.Context) {
+	b.deliverMu.Lock()
+	defer b.deliverMu.Unlock()
+
+	now := time.Now()
+	b.mu.Lock()
+	due := make([]*outboxItem, 0, len(b.Pending))
+	held := false
+	for _, item := range b.Pending {
+		if item.NextAttempt.After(now) {
+			continue
+		}
+		// Hold everything found during a search's quiet hours, and send it all once they are over
+		if s := findSearch(item.Search); s != nil {
+			if until, quiet := s.quietUntil(now); quiet {
+				logger.Info("holding product until the quiet hours are over", "search", item.Search, "sink", item.Sink,
+					"product_id", item.Product.ID, "title", item.Product.Title, "until", until)
+				item.NextAttempt = until
+				held = true
+				continue
+			}
+		}
+		due = append(due, item)
+	}
+	if held {
+		b.saveOrLog()
+	}
+	b.mu.Unlock()
+
+	failed := make(map[string]bool)
+	for _, item := range due {
+		if ctx.Err() != nil {
+			logger.Info("stopped delivering, the rest will be sent later", "error", ctx.Err())
+			return
+		}
+		key := item.Search + "\x00" + item.Sink
+		if failed[key] {
+			continue
+		}
+
+		// The email digest takes its items from the outbox when it is sent
+		if item.Sink == emailSink && digest != nil {
+			continue
+		}
+
+		n := findNotifier(item.Search, item.Sink)
+		if n != nil && n.Name() != item.Sink {
+			// Found under its old name, which is replaced so it is no longer saved
+			b.mu.Lock()
+			item.Sink = n.Name()
+			b.mu.Unlock()
+		}
+		var err error
+		if n == nil {
+			err = fmt.Errorf("%s is no longer configured for search %q", item.Sink, item.Search)
+		} else {
+			err = notify(withDelivery(ctx, item.ID), n, item.Product)
+		}
+		if err != nil && ctx.Err() != nil {
+			logger.Info("stopped delivering, the rest will be sent later", "error", ctx.Err())
+			return
+		}
+
+		b.mu.Lock()
+		if err == nil {
+			sentTotal.Inc(item.Search, sinkKind(n))
+			b.delivered(item)
+		} else {
+			failTotal.Inc(item.Search, sinkKind(n))
+			failed[key] = true
+			b.failed(item, err, n == nil)
+		}
+		b.saveOrLog()
+		b.mu.Unlock()
+
+		if err == nil {
+			alerts.success(sinkSubject(item.Search, item.Sink))
+		} else {
+			alerts.failure(sinkSubject(item.Search, item.Sink), item.Product.Link, err)
+		}
+	}
+}
+
+// digestItems returns the items waiting for the next email digest, in the order they were queued
+func (b *outbox) digestItems() []*outboxItem {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	items := make([]*outboxItem, 0)
+	for _, item := range b.Pending {
+		if item.Sink == emailSink {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// digestSent records the items of an email digest that was sent as delivered
+func (b *outbox) digestSent(items []*outboxItem) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, item := range items {
+		sentTotal.Inc(item.Search, "email")
+		b.delivered(item)
+	}
+	b.saveOrLog()
+}
+
+// digestFailed records that an email digest could not be sent. The items stay
+// in the outbox for the next digest, they are not moved to the dead letters.
+func (b *outbox) digestFailed(items []*outboxItem, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, item := range items {
+		failTotal.Inc(item.Search, "email")
+		item.Attempts++
+		item.LastError = redactError(err)
+	}
+	b.saveOrLog()
+}
+
+// deliveryKey is the context key of the ID of the outbox item being delivered
+type deliveryKey struct{}
+
+// withDelivery returns a context carrying the ID of the outbox item being delivered
+func withDelivery(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, deliveryKey{}, id)
+}
+
+// deliveryID returns the ID of the outbox item being delivered, which is the same for every attempt
+func deliveryID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(deliveryKey{}).(string)
+	return id, ok
+}
+
+// notify sends the product, turning a panic in the notifier into an error,
+// so one broken sink can't take down the bot
+func notify(ctx context.Context, n Notifier, p Product) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while sending to %s: %v", n.Name(), r)
+		}
+	}()
+	return n.Notify(ctx, p)
+}
+
+// delivered removes a successfully sent item, and records the product as delivered
+// once every notifier of the search has it. The caller must hold mu.
+func (b *outbox) delivered(item *outboxItem) {
+	b.Pending = removeItem(b.Pending, item)
+	for _, other := range b.Pending {
+		if other.Search == item.Search && other.Product.Title == item.Product.Title {
+			return
+		}
+	}
+	b.Delivered[item.Search] = append(b.Delivered[item.Search], seenProduct{ID: item.Product.ID, Title: item.Product.Title})
+}
+
+// failed schedules the next attempt with exponential backoff, or moves the item
+// to the dead letters once it is out of attempts. The caller must hold mu.
+func (b *outbox) failed(item *outboxItem, err error, permanent bool) {
+	item.Attempts++
+	item.LastError = redactError(err)
+	if permanent || item.Attempts >= b.maxAttempts {
+		logger.Error("giving up on sending product", "search", item.Search, "sink", item.Sink, "product_id", item.Product.ID, "title", item.Product.Title, "attempts", item.Attempts, "error", err)
+		b.Pending = removeItem(b.Pending, item)
+		b.Dead = append(b.Dead, item)
+		if len(b.Dead) > outboxDeadLimit {
+			b.Dead = b.Dead[len(b.Dead)-outboxDeadLimit:]
+		}
+		return
+	}
+
+	wait := outboxFirstBackoff
+	for i := 1; i < item.Attempts && wait < outboxMaxBackoff; i++ {
+		wait = wait * 2
+	}
+	if wait > outboxMaxBackoff {
+		wait = outboxMaxBackoff
+	}
+	item.NextAttempt = time.Now().Add(wait)
+	logger.Warn("could not send product, will retry", "search", item.Search, "sink", item.Sink, "product_id", item.Product.ID, "title", item.Product.Title, "attempts", item.Attempts, "retry_in", wait, "error", err)
+}
+
+// sizes returns the number of delivered products, pending items and dead items
+func (b *outbox) sizes() (delivered, pending, dead int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, seen := range b.Delivered {
+		delivered += len(seen)
+	}
+	return delivered, len(b.Pending), len(b.Dead)
+}
+
+// run delivers due items every interval, until stop is closed or the context is cancelled.
+// Closing stop lets a delivery that is running finish, cancelling ctx doesn't.
+func (b *outbox) run(ctx context.Context, stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.deliver(ctx)
+		}
+	}
+}
+
+// flush saves the outbox to the state file
+func (b *outbox) flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.save()
+}
+
+// printDead writes the dead-lettered items to stdout.
+func (b *outbox) printDead() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.Dead) == 0 {
+		fmt.Println("No dead-lettered items.")
+		return
+	}
+	for _, item := range b.Dead {
+		fmt.Println(item.Created.Format("2006-01-02 15:04:05") + "  " + item.Search + "  " + item.Sink)
+		fmt.Println("  Product : " + item.Product.Title)
+		fmt.Println("  Attempts: " + strconv.Itoa(item.Attempts))
+		fmt.Println("  Error   : " + redact(item.LastError))
+	}
+}
+
+// printState prints what was delivered today, and what is waiting in the outbox or was given up on.
+func (b *outbox) printState() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fmt.Println("Delivered on " + b.Date + ":")
+	names := make([]string, 0, len(b.Delivered))
+	for name := range b.Delivered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, seen := range b.Delivered[name] {
+			fmt.Printf("  %-20s %-8s %s\n", name, seen.ID, seen.Title)
+		}
+	}
+	fmt.Println("Pending:")
+	for _, item := range b.Pending {
+		fmt.Printf("  %-20s %-8s %s (%s, %d attempts, next %s)\n", item.Search, item.Product.ID, item.Product.Title,
+			item.Sink, item.Attempts, item.NextAttempt.Format("2006-01-02 15:04:05"))
+	}
+	fmt.Println("Dead letters:")
+	for _, item := range b.Dead {
+		fmt.Printf("  %-20s %-8s %s (%s: %s)\n", item.Search, item.Product.ID, item.Product.Title, item.Sink, redact(item.LastError))
+	}
+}
+
+// forget removes a product, by ID or title, from everything the outbox knows about,
+// so it is posted again the next time it is found. It returns how many entries were removed.
+func (b *outbox) forget(idOrTitle string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	removed := 0
+	for name, seen := range b.Delivered {
+		kept := seen[:0]
+		for _, p := range seen {
+			if p.ID == idOrTitle || p.Title == idOrTitle {
+				removed++
+				continue
+			}
+			kept = append(kept, p)
+		}
+		b.Delivered[name] = kept
+	}
+	matches := func(item *outboxItem) bool {
+		return item.Product.ID == idOrTitle || item.Product.Title == idOrTitle
+	}
+	for _, item := range append(append([]*outboxItem{}, b.Pending...), b.Dead...) {
+		if matches(item) {
+			b.Pending = removeItem(b.Pending, item)
+			b.Dead = removeItem(b.Dead, item)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, b.save()
+}
+
+// reset forgets everything: what was delivered, what is pending and the dead letters.
+func (b *outbox) reset() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Delivered = make(map[string][]seenProduct)
+	b.Pending = nil
+	b.Dead = nil
+	return b.save()
+}
+
+// removeItem returns items without item, keeping the order
+func removeItem(items []*outboxItem, item *outboxItem) []*outboxItem {
+	result := items[:0]
+	for _, i := range items {
+		if i != item {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// findSearch looks up a search by name
+func findSearch(name string) *search {
+	for _, s := range searches {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// findNotifier looks up a notifier by search and notifier name
+func findNotifier(searchName, sink string) Notifier {
+	s := findSearch(searchName)
+	if s == nil {
+		return nil
+	}
+	for _, n := range s.notifiers {
+		if n.Name() == sink {
+			return n
+		}
+		// Webhooks used to be named after their URL
+		if wh, ok := n.(*webhookNotifier); ok && sink == "webhook "+wh.url {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records what it is sent, and fails with err when it is set
+type fakeNotifier struct {
+	name string
+	err  error
+	sent []Product
+}
+
+func (n *fakeNotifier) Name() string {
+	return n.name
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, p Product) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.sent = append(n.sent, p)
+	return nil
+}
+
+// withSearches replaces the configured searches for the duration of a test
+func withSearches(t *testing.T, list ...*search) {
+	t.Helper()
+	saved := searches
+	searches = list
+	t.Cleanup(func() { searches = saved })
+}
+
+// newTestSearch returns a search in UTC that posts to the notifiers
+func newTestSearch(name string, notifiers ...Notifier) *search {
+	s := &search{notifiers: notifiers, location: time.UTC}
+	s.Name = name
+	return s
+}
+
+func TestOutboxDeliver(t *testing.T) {
+	down := errors.New("connection refused")
+	tests := []struct {
+		name string
+		// errors of the first and second sink
+		errs [2]error
+		// attempts the item already had before this one
+		attempts      int
+		wantDelivered bool
+		wantPending   []string
+		wantDead      []string
+	}{
+		{name: "every sink accepts", wantDelivered: true},
+		{name: "one sink fails", errs: [2]error{nil, down}, wantPending: []string{"second"}},
+		{name: "both sinks fail", errs: [2]error{down, down}, wantPending: []string{"first", "second"}},
+		{name: "out of attempts", errs: [2]error{nil, down}, attempts: 2, wantDead: []string{"second"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := &fakeNotifier{name: "first", err: tt.errs[0]}
+			second := &fakeNotifier{name: "second", err: tt.errs[1]}
+			withSearches(t, newTestSearch("fg", first, second))
+			b := withOutbox(t)
+			b.enqueue(findSearch("fg"), Product{ID: "1", Title: "Sharn"})
+			for _, item := range b.Pending {
+				item.Attempts = tt.attempts
+			}
+
+			b.deliver(context.Background())
+
+			// The state file has to agree with the outbox in memory
+			saved, err := loadOutbox(b.path, b.maxAttempts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, got := range []*outbox{b, saved} {
+				if delivered := got.known("fg", "Sharn") && len(got.Delivered["fg"]) == 1; delivered != tt.wantDelivered {
+					t.Errorf("delivered %v, want %v", got.Delivered, tt.wantDelivered)
+				}
+				if sinks := itemSinks(got.Pending); !equalStrings(sinks, tt.wantPending) {
+					t.Errorf("pending %v, want %v", sinks, tt.wantPending)
+				}
+				if sinks := itemSinks(got.Dead); !equalStrings(sinks, tt.wantDead) {
+					t.Errorf("dead %v, want %v", sinks, tt.wantDead)
+				}
+			}
+			for _, item := range b.Pending {
+				if item.Attempts != tt.attempts+1 || item.LastError != down.Error() {
+					t.Errorf("%s: attempts %d, last error %q", item.Sink, item.Attempts, item.LastError)
+				}
+				if !item.NextAttempt.After(time.Now()) {
+					t.Errorf("%s: retried straight away", item.Sink)
+				}
+			}
+			if tt.errs[0] == nil && len(first.sent) != 1 {
+				t.Errorf("first sink was sent %d products", len(first.sent))
+			}
+		})
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	b := withOutbox(t)
+	b.maxAttempts = 20
+	item := &outboxItem{ID: "1", Search: "fg", Sink: "first"}
+	b.Pending = []*outboxItem{item}
+
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute,
+		16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour}
+	for i, w := range want {
+		start := time.Now()
+		b.failed(item, errors.New("timeout"), false)
+		wait := item.NextAttempt.Sub(start)
+		if wait < w || wait > w+time.Second {
+			t.Errorf("attempt %d: waiting %s, want %s", i+1, wait, w)
+		}
+	}
+	if len(b.Pending) != 1 || len(b.Dead) != 0 {
+		t.Errorf("pending %d, dead %d", len(b.Pending), len(b.Dead))
+	}
+}
+
+func TestOutboxNotConfigured(t *testing.T) {
+	n := &fakeNotifier{name: "first"}
+	withSearches(t, newTestSearch("fg", n))
+	b := withOutbox(t, &outboxItem{ID: "1", Search: "fg", Sink: "removed", Product: Product{Title: "Sharn"}})
+
+	b.deliver(context.Background())
+	// A sink that is no longer configured will never work, so there is no point in retrying
+	if len(b.Pending) != 0 || len(b.Dead) != 1 || b.Dead[0].Attempts != 1 {
+		t.Errorf("pending %d, dead %d", len(b.Pending), len(b.Dead))
+	}
+}
+
+func TestOutboxQuietHours(t *testing.T) {
+	n := &fakeNotifier{name: "first"}
+	s := newTestSearch("fg", n)
+	now := time.Now().UTC()
+	m := now.Hour()*60 + now.Minute()
+	s.quiet = &quietHours{start: (m + 1380) % 1440, end: (m + 60) % 1440}
+	withSearches(t, s)
+	b := withOutbox(t)
+	b.enqueue(s, Product{ID: "1", Title: "Sharn"})
+
+	b.deliver(context.Background())
+	if len(n.sent) != 0 {
+		t.Fatal("sent during the quiet hours")
+	}
+	if len(b.Pending) != 1 || b.Pending[0].Attempts != 0 {
+		t.Fatalf("the product should be held without using up an attempt: %+v", b.Pending)
+	}
+	until, _ := s.quietUntil(now)
+	if !b.Pending[0].NextAttempt.Equal(until) {
+		t.Errorf("held until %s, want %s", b.Pending[0].NextAttempt, until)
+	}
+
+	s.quiet = nil
+	b.Pending[0].NextAttempt = now
+	b.deliver(context.Background())
+	if len(n.sent) != 1 || !b.known("fg", "Sharn") || len(b.Pending) != 0 {
+		t.Errorf("not sent once the quiet hours are over")
+	}
+}
+
+func TestOutboxOldWebhookName(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	n := &webhookNotifier{url: srv.URL + "/hook", search: "fg"}
+	withSearches(t, newTestSearch("fg", n))
+	// Saved before webhooks were named without their URL
+	b := withOutbox(t, &outboxItem{ID: "1", Search: "fg", Sink: "webhook " + srv.URL + "/hook", Product: Product{Title: "Sharn"}})
+
+	if findNotifier("fg", b.Pending[0].Sink) != n {
+		t.Fatal("the webhook is not found under its old name")
+	}
+	b.deliver(context.Background())
+	if len(b.Pending) != 0 || len(b.Dead) != 0 || !b.known("fg", "Sharn") {
+		t.Errorf("not delivered: pending %d, dead %d", len(b.Pending), len(b.Dead))
+	}
+}
+
+// itemSinks returns the sinks of the items, in order
+func itemSinks(items []*outboxItem) []string {
+	var sinks []string
+	for _, item := range items {
+		sinks = append(sinks, item.Sink)
+	}
+	return sinks
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// unsafeFileChars matches anything we don't want in a quarantine file name
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// quarantineRow saves the raw HTML of a row that could not be parsed to the quarantine directory,
+// with the reason at the top, so the parser can be fixed later. It returns the path of the file.
+// Files are named after the row's content, so a row that keeps failing is only saved once.
+func quarantineRow(dir, searchName string, index int, row string, reason string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum([]byte(row))
+	name := unsafeFileChars.ReplaceAllString(searchName, "_") + "-" + hex.EncodeToString(sum[:8]) + ".html"
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<!--\n")
+	buf.WriteString("search: " + searchName + "\n")
+	buf.WriteString("row: " + fmt.Sprint(index) + "\n")
+	buf.WriteString("time: " + time.Now().Format(time.RFC3339) + "\n")
+	// "--" would end the comment early
+	buf.WriteString("reason: " + strings.Replace(reason, "--", "- -", -1) + "\n")
+	buf.WriteString("-->\n")
+	buf.WriteString(row)
+	buf.WriteString("\n")
+
+	return path, ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+// watchConfig reports when the configuration should be reloaded: on SIGHUP, and when
+// the file's modification time or size changes (checked every interval, 0 turns that off).
+// The reason is sent on the returned channel.
+func watchConfig(ctx context.Context, path string, interval time.Duration) <-chan string {
+	reloads := make(chan string, 1)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		tick = ticker.C
+		go func() {
+			<-ctx.Done()
+			ticker.Stop()
+		}()
+	}
+
+	go func() {
+		defer signal.Stop(hup)
+		last, _ := os.Stat(path)
+		for {
+			var reason string
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reason = "SIGHUP"
+			case <-tick:
+				info, err := os.Stat(path)
+				if err != nil || (last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size()) {
+					continue
+				}
+				last = info
+				reason = "file changed"
+			}
+			// If a reload is already waiting, it will pick up this change as well
+			select {
+			case reloads <- reason:
+			default:
+			}
+		}
+	}()
+	return reloads
+}
+
+// reloadConfig re-reads the configuration and swaps in the new searches, filters and channels.
+// It waits for a running check or delivery to finish first, so they never see half of each.
+// If the new configuration can't be read or is invalid, nothing changes.
+// The outbox and what was already delivered today are kept as they are.
+func reloadConfig(path string) error {
+	var next Config
+	if err := cleanenv.ReadConfig(path, &next); err != nil {
+		return err
+	}
+	problems := readSecretFiles(&next)
+	if problems = append(problems, validateConfig(next, false)...); len(problems) > 0 {
+		msgs := make([]string, 0, len(problems))
+		for _, p := range problems {
+			msgs = append(msgs, p.Error())
+		}
+		return fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+	for _, name := range keepRestartSettings(&next, cfg) {
+		logger.Warn("changing this setting needs a restart, keeping the old value", "setting", name)
+	}
+
+	cycleMu.Lock()
+	defer cycleMu.Unlock()
+	box.deliverMu.Lock()
+	defer box.deliverMu.Unlock()
+
+	prevDiscord := discord
+	if next.Discord.Token != cfg.Discord.Token {
+		session, err := discordgo.New("Bot " + next.Discord.Token)
+		if err != nil {
+			return err
+		}
+		discord = session
+	}
+	if err := setup(next); err != nil {
+		discord = prevDiscord
+		return err
+	}
+	if discord != prevDiscord {
+		prevDiscord.Close()
+	}
+
+	cfgMu.Lock()
+	cfg = next
+	cfgMu.Unlock()
+	client = newClient(cfg)
+	logger.Configure(cfg.Settings.LogLevel, cfg.Settings.LogFormat)
+	box.mu.Lock()
+	box.maxAttempts = cfg.Settings.MaxAttempts
+	box.mu.Unlock()
+	health.configure(cfg.Health.StaleFactor)
+	alerts.configure(cfg.Alerts.Channel, cfg.Alerts.Users, cfg.Alerts.After, cfg.Alerts.MinInterval)
+	return nil
+}
+
+// keepRestartSettings copies the settings that can't be changed while running from the
+// current configuration into the next one, and returns the names of those that differed.
+func keepRestartSettings(next *Config, current Config) []string {
+	changed := make([]string, 0)
+	if next.Server != current.Server {
+		changed = append(changed, "server")
+		next.Server = current.Server
+	}
+	if next.Feeds.Enabled != current.Feeds.Enabled {
+		changed = append(changed, "feeds.enabled")
+		next.Feeds.Enabled = current.Feeds.Enabled
+	}
+	if next.Metrics != current.Metrics {
+		changed = append(changed, "metrics.enabled")
+		next.Metrics = current.Metrics
+	}
+	if next.Health.Enabled != current.Health.Enabled {
+		changed = append(changed, "health.enabled")
+		next.Health.Enabled = current.Health.Enabled
+	}
+	if next.Settings.StateFile != current.Settings.StateFile {
+		changed = append(changed, "settings.state_file")
+		next.Settings.StateFile = current.Settings.StateFile
+	}
+	return changed
+}
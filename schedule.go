@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cronSchedule is a parsed 5 field cron expression: minute, hour, day of month, month and day of week.
+// Every field is a bit set of the values that match.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Like cron, when both the day of month and the day of week are restricted,
+	// a day matches if either of them does.
+	domAny, dowAny bool
+}
+
+// cronMacros are the @ shorthands cron understands
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronMonths and cronDays are the names that can be used in the month and day of week fields
+var cronMonths = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+var cronDays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+// parseCron parses a standard 5 field cron expression, like "*/15 8-22 * * mon-fri".
+// Each field can be *, a number, a range (1-5), a step (*/15 or 1-30/5) or a comma separated list of those.
+func parseCron(expr string) (*cronSchedule, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields (minute hour day-of-month month day-of-week), not %d", expr, len(fields))
+	}
+
+	c := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron expression %q: minute: %v", expr, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron expression %q: hour: %v", expr, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron expression %q: day of month: %v", expr, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("cron expression %q: month: %v", expr, err)
+	}
+	// 7 is Sunday as well
+	if c.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, fmt.Errorf("cron expression %q: day of week: %v", expr, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	if c.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", expr)
+	}
+	return c, nil
+}
+
+// parseCronField parses a single field of a cron expression into a bit set
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = cronValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = cronValue(bounds[1], names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// 5/15 means from 5 to the end, every 15
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is outside of %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// cronValue parses a number or, for months and days of the week, a name
+func cronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return v, nil
+}
+
+// matchesDay reports whether the schedule runs on t's day
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t that the schedule matches, in t's time zone.
+// It returns the zero time if there is none in the next 5 years.
+// Every wall clock time matches at most once. A time that is skipped when the clocks go forward
+// runs that much later (30 2 * * * at 3:30 when 2:00 becomes 3:00), and a time that happens twice
+// when the clocks go back only runs the first time.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	// Go through the wall clock times in UTC, which has no daylight saving time to skip or repeat any
+	w := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	limit := w.AddDate(5, 0, 0)
+	for w.Before(limit) {
+		switch {
+		case c.month&(1<<uint(w.Month())) == 0:
+			w = time.Date(w.Year(), w.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.matchesDay(w):
+			w = time.Date(w.Year(), w.Month(), w.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<uint(w.Hour())) == 0:
+			w = time.Date(w.Year(), w.Month(), w.Day(), w.Hour()+1, 0, 0, 0, time.UTC)
+		case c.minute&(1<<uint(w.Minute())) == 0:
+			w = w.Add(time.Minute)
+		default:
+			// A wall clock time from before t, when t is in the hour that happens twice, has already run
+			if r := wallTime(w, loc); r.After(t) {
+				return r
+			}
+			w = w.Add(time.Minute)
+		}
+	}
+	return time.Time{}
+}
+
+// wallTime returns the first moment the clocks in loc show the date and time of w (which is in UTC).
+// If they never do, because the clocks went forward, it returns the moment they would have
+// without the change, which is as much later on the new clock.
+func wallTime(w time.Time, loc *time.Location) time.Time {
+	guess := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), 0, 0, loc)
+	// The offsets before and after any change of the clocks around w
+	_, before := guess.Add(-3 * time.Hour).Zone()
+	_, after := guess.Add(3 * time.Hour).Zone()
+	first := w.Add(-time.Duration(before) * time.Second).In(loc)
+	second := w.Add(-time.Duration(after) * time.Second).In(loc)
+	if showsWallTime(first, w) && (!showsWallTime(second, w) || first.Before(second)) {
+		return first
+	}
+	if showsWallTime(second, w) {
+		return second
+	}
+	return first
+}
+
+// showsWallTime reports whether the clocks show w's date and time (to the minute) at t
+func showsWallTime(t, w time.Time) bool {
+	return t.Year() == w.Year() && t.Month() == w.Month() && t.Day() == w.Day() && t.Hour() == w.Hour() && t.Minute() == w.Minute()
+}
+
+// maxGap returns the longest time between two runs of the schedule, starting in the week after t
+func (c *cronSchedule) maxGap(t time.Time) time.Duration {
+	var gap time.Duration
+	end := t.AddDate(0, 0, 7)
+	for prev := c.next(t); !prev.IsZero() && prev.Before(end); {
+		n := c.next(prev)
+		if n.IsZero() {
+			break
+		}
+		if n.Sub(prev) > gap {
+			gap = n.Sub(prev)
+		}
+		prev = n
+	}
+	if gap == 0 {
+		// Runs less than once a week
+		first := c.next(t)
+		gap = c.next(first).Sub(first)
+	}
+	return gap
+}
+
+// quietHours is a daily period, like 22:00-07:00, during which nothing is posted.
+// Times are minutes after midnight.
+type quietHours struct {
+	start, end int
+}
+
+// parseQuietHours parses a period like "22:00-07:00"
+func parseQuietHours(s string) (*quietHours, error) {
+	bounds := strings.Split(strings.TrimSpace(s), "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("quiet hours %q must look like 22:00-07:00", s)
+	}
+	q := &quietHours{}
+	for i, b := range bounds {
+		t, err := time.Parse("15:04", strings.TrimSpace(b))
+		if err != nil {
+			return nil, fmt.Errorf("quiet hours %q must look like 22:00-07:00", s)
+		}
+		if i == 0 {
+			q.start = t.Hour()*60 + t.Minute()
+		} else {
+			q.end = t.Hour()*60 + t.Minute()
+		}
+	}
+	if q.start == q.end {
+		return nil, fmt.Errorf("quiet hours %q start and end at the same time", s)
+	}
+	return q, nil
+}
+
+// until reports whether t is in the quiet period, and if so when the period ends
+func (q *quietHours) until(t time.Time) (time.Time, bool) {
+	m := t.Hour()*60 + t.Minute()
+	var quiet bool
+	if q.start < q.end {
+		quiet = m >= q.start && m < q.end
+	} else {
+		quiet = m >= q.start || m < q.end
+	}
+	if !quiet {
+		return time.Time{}, false
+	}
+	day := t.Day()
+	if q.start > q.end && m >= q.start {
+		// The period ends tomorrow
+		day++
+	}
+	return time.Date(t.Year(), t.Month(), day, q.end/60, q.end%60, 0, 0, t.Location()), true
+}
+
+// parseSchedule parses the cron expression, time zone and quiet hours of a search.
+// Searches without a time zone use the local one.
+func parseSchedule(sc SearchConfig) (*cronSchedule, *time.Location, *quietHours, error) {
+	loc := time.Local
+	if sc.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(sc.Timezone)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unknown time zone %q: %v", sc.Timezone, err)
+		}
+	}
+	var c *cronSchedule
+	if sc.Schedule != "" {
+		var err error
+		if c, err = parseCron(sc.Schedule); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	var q *quietHours
+	if sc.QuietHours != "" {
+		var err error
+		if q, err = parseQuietHours(sc.QuietHours); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return c, loc, q, nil
+}
+
+// quietUntil reports whether the search is in its quiet hours, and if so when they end
+func (s *search) quietUntil(now time.Time) (time.Time, bool) {
+	if s.quiet == nil {
+		return time.Time{}, false
+	}
+	return s.quiet.until(now.In(s.location))
+}
+
+// tz returns the time zone of the search's schedule and quiet hours
+func (s *search) tz() *time.Location {
+	if s.location == nil {
+		return time.Local
+	}
+	return s.location
+}
+
+// pollPlan is when a search is polled next, and the interval adaptive polling has got to.
+// key is the schedule the plan was made for, so a reload only re-plans the searches whose schedule changed.
+type pollPlan struct {
+	next     time.Time
+	interval time.Duration
+	key      string
+}
+
+// scheduleKey describes when the search is polled. It changes when the search's
+// cron expression or time zone, or the polling settings it uses, change.
+func (s *search) scheduleKey(conf Config) string {
+	if s.cron != nil {
+		return "cron " + s.Schedule + " " + s.tz().String()
+	}
+	if conf.Polling.Adaptive {
+		return fmt.Sprintf("adaptive %d-%d", conf.Polling.MinMinutes, conf.Polling.MaxMinutes)
+	}
+	return fmt.Sprintf("every %d", conf.Settings.Minutes)
+}
+
+// plan works out when to poll the search next: at the next time matching its cron expression,
+// or after settings.minutes for searches without one. With adaptive polling, the interval
+// drops to polling.min_minutes after new products are found and during the hours products
+// are usually found in, and grows by half after every poll that found nothing, up to
+// polling.max_minutes. Random jitter is added, so several bots don't poll in lockstep,
+// without ever taking the adaptive wait outside of those bounds.
+// conf is a snapshot of the configuration, since a reload may swap it at any time.
+func (s *search) plan(conf Config, now time.Time, prev pollPlan, found int) pollPlan {
+	key := s.scheduleKey(conf)
+	if s.cron != nil {
+		return pollPlan{next: s.cron.next(now.In(s.tz())).Add(jitter(conf.Polling.Jitter)), key: key}
+	}
+	if prev.key != key {
+		// The adaptive interval starts over when the settings change
+		prev.interval = 0
+	}
+
+	interval := conf.Settings.Minutes.Duration()
+	wait := interval + jitter(conf.Polling.Jitter)
+	if conf.Polling.Adaptive {
+		min, max := conf.Polling.MinMinutes.Duration(), conf.Polling.MaxMinutes.Duration()
+		switch {
+		case found > 0 || box.busyHour(s.Name, now.In(s.tz()).Hour()):
+			interval = min
+		case prev.interval > 0:
+			interval = prev.interval * 3 / 2
+		}
+		if interval < min {
+			interval = min
+		}
+		if interval > max {
+			interval = max
+		}
+		// The jitter must not take the wait past the bounds either,
+		// so near the maximum it is taken off instead of added
+		wait = interval + jitter(conf.Polling.Jitter)
+		if wait > max {
+			wait = max - jitter(conf.Polling.Jitter)
+		}
+		if wait < min {
+			wait = min
+		}
+	}
+	pollIntervalSeconds.Set(interval.Seconds(), s.Name)
+	return pollPlan{next: now.Add(wait), interval: interval, key: key}
+}
+
+// jitter returns a random delay of up to max
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// pollInterval is how long the search can normally go between polls, used to work out when it is stale
+func (s *search) pollInterval(conf Config) time.Duration {
+	if s.cron != nil {
+		return s.cronGap
+	}
+	if conf.Polling.Adaptive {
+		return conf.Polling.MaxMinutes.Duration()
+	}
+	return conf.Settings.Minutes.Duration()
+}
+
+// running are the searches being checked right now, by name
+var runningMu sync.Mutex
+var running = make(map[string]bool)
+
+// claimSearches marks the searches in list as being checked, and returns them,
+// leaving out the ones that are still being checked, so a search is never checked twice at once.
+// Call releaseSearches with the result when the check is done.
+func claimSearches(list []*search) []*search {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	claimed := make([]*search, 0, len(list))
+	for _, s := range list {
+		if running[s.Name] {
+			pollsTotal.Inc(s.Name, "overlap")
+			logger.Warn("search is still being checked, skipping it this time", "search", s.Name)
+			continue
+		}
+		running[s.Name] = true
+		claimed = append(claimed, s)
+	}
+	return claimed
+}
+
+// releaseSearches marks the searches in list as no longer being checked
+func releaseSearches(list []*search) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	for _, s := range list {
+		delete(running, s.Name)
+	}
+}
+
+// runSearches polls the searches when they are due, until something is sent on stop.
+// The searches are looked up every time, so reloads are picked up: new searches are planned,
+// the plans of removed ones are dropped, and only the searches whose schedule changed are
+// planned again, so a reload doesn't push every search back.
+// The due searches are polled in the background, so a slow poll doesn't hold up the searches
+// that are due after it, and a search is only planned again once its poll has finished.
+func runSearches(ctx context.Context, stop chan bool, tick time.Duration, poll func(ctx context.Context, list []*search) error) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	quit := make(chan struct{})
+	defer close(quit)
+
+	done := make(chan []*search)
+	plans := make(map[string]pollPlan)
+	polling := make(map[string]bool)
+	for {
+		select {
+		case <-stop:
+			return
+		case list := <-done:
+			now := time.Now()
+			conf := currentConfig()
+			current := make(map[string]*search)
+			for _, s := range currentSearches() {
+				current[s.Name] = s
+			}
+			for _, polled := range list {
+				delete(polling, polled.Name)
+				// The search may have been changed or removed by a reload while it was polled
+				s, ok := current[polled.Name]
+				if !ok {
+					delete(plans, polled.Name)
+					continue
+				}
+				plans[s.Name] = s.plan(conf, now, plans[s.Name], polled.found)
+				logger.Debug("planned next poll", "search", s.Name, "found", polled.found, "next", plans[s.Name].next)
+			}
+		case now := <-ticker.C:
+			conf := currentConfig()
+			list := currentSearches()
+			due := make([]*search, 0)
+			names := make(map[string]bool, len(list))
+			for _, s := range list {
+				names[s.Name] = true
+				if polling[s.Name] {
+					continue
+				}
+				p, ok := plans[s.Name]
+				if !ok || p.key != s.scheduleKey(conf) {
+					plans[s.Name] = s.plan(conf, now, p, 0)
+					if ok {
+						logger.Info("schedule changed, planned next poll", "search", s.Name, "next", plans[s.Name].next)
+					}
+					continue
+				}
+				if !now.Before(p.next) {
+					due = append(due, s)
+				}
+			}
+			for name := range plans {
+				if !names[name] && !polling[name] {
+					delete(plans, name)
+				}
+			}
+			if len(due) == 0 {
+				continue
+			}
+			for _, s := range due {
+				polling[s.Name] = true
+			}
+			go func(due []*search) {
+				supervise("check", func() error { return poll(ctx, due) })
+				select {
+				case done <- due:
+				case <-quit:
+				}
+			}(due)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// mustLocation loads a time zone, failing the test if it isn't known
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseCronField(t *testing.T) {
+	bits := func(values ...int) uint64 {
+		var b uint64
+		for _, v := range values {
+			b |= 1 << uint(v)
+		}
+		return b
+	}
+	tests := []struct {
+		field    string
+		min, max int
+		names    map[string]int
+		want     uint64
+	}{
+		{"*", 0, 6, cronDays, bits(0, 1, 2, 3, 4, 5, 6)},
+		{"5", 0, 59, nil, bits(5)},
+		{"1-3", 0, 59, nil, bits(1, 2, 3)},
+		{"*/15", 0, 59, nil, bits(0, 15, 30, 45)},
+		{"10-30/10", 0, 59, nil, bits(10, 20, 30)},
+		{"50/5", 0, 59, nil, bits(50, 55)},
+		{"1,15,30", 1, 31, nil, bits(1, 15, 30)},
+		{"mon-fri", 0, 7, cronDays, bits(1, 2, 3, 4, 5)},
+		{"SAT,sun", 0, 7, cronDays, bits(0, 6)},
+		{"jan,jun-aug", 1, 12, cronMonths, bits(1, 6, 7, 8)},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.min, tt.max, tt.names)
+		if err != nil {
+			t.Errorf("%q: %v", tt.field, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %b, want %b", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"x * * * *",
+		"@often",
+		// Valid fields, but there is no February 30th
+		"0 0 30 feb *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+	newYork := mustLocation(t, "America/New_York")
+	utc := time.UTC
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every 15 minutes", "*/15 * * * *", time.Date(2026, 10, 18, 10, 7, 30, 0, utc), time.Date(2026, 10, 18, 10, 15, 0, 0, utc)},
+		{"on the minute is not after", "*/15 * * * *", time.Date(2026, 10, 18, 10, 15, 0, 0, utc), time.Date(2026, 10, 18, 10, 30, 0, 0, utc)},
+		{"weekdays from saturday", "0 9 * * mon-fri", time.Date(2026, 10, 17, 12, 0, 0, 0, utc), time.Date(2026, 10, 19, 9, 0, 0, 0, utc)},
+		{"sunday as 7", "0 9 * * 7", time.Date(2026, 10, 17, 12, 0, 0, 0, utc), time.Date(2026, 10, 18, 9, 0, 0, 0, utc)},
+		{"hourly", "@hourly", time.Date(2026, 10, 18, 10, 59, 0, 0, utc), time.Date(2026, 10, 18, 11, 0, 0, 0, utc)},
+		{"daily", "@daily", time.Date(2026, 10, 18, 10, 0, 0, 0, utc), time.Date(2026, 10, 19, 0, 0, 0, 0, utc)},
+		{"weekly", "@weekly", time.Date(2026, 10, 18, 10, 0, 0, 0, utc), time.Date(2026, 10, 25, 0, 0, 0, 0, utc)},
+		{"monthly", "@monthly", time.Date(2026, 12, 18, 10, 0, 0, 0, utc), time.Date(2027, 1, 1, 0, 0, 0, 0, utc)},
+		{"yearly", "@yearly", time.Date(2026, 10, 18, 10, 0, 0, 0, utc), time.Date(2027, 1, 1, 0, 0, 0, 0, utc)},
+		{"skips short months", "0 0 31 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, utc), time.Date(2026, 5, 31, 0, 0, 0, 0, utc)},
+		{"leap day", "0 0 29 feb *", time.Date(2026, 3, 1, 0, 0, 0, 0, utc), time.Date(2028, 2, 29, 0, 0, 0, 0, utc)},
+
+		// When both the day of month and the day of week are restricted, either one matches
+		{"friday before the 13th", "0 0 13 * fri", time.Date(2026, 10, 1, 12, 0, 0, 0, utc), time.Date(2026, 10, 2, 0, 0, 0, 0, utc)},
+		{"13th before friday", "0 0 13 * fri", time.Date(2026, 10, 10, 12, 0, 0, 0, utc), time.Date(2026, 10, 13, 0, 0, 0, 0, utc)},
+		// ...but with only one of them restricted, only that one counts
+		{"only the 13th", "0 0 13 * *", time.Date(2026, 10, 1, 12, 0, 0, 0, utc), time.Date(2026, 10, 13, 0, 0, 0, 0, utc)},
+		{"only fridays", "0 0 * * fri", time.Date(2026, 10, 10, 12, 0, 0, 0, utc), time.Date(2026, 10, 16, 0, 0, 0, 0, utc)},
+
+		// Clocks go forward at 2:00, so 2:30 doesn't exist that day and runs an hour later on the new clock
+		{"spring forward, skipped time", "30 2 * * *", time.Date(2026, 3, 28, 2, 30, 0, 0, berlin), time.Date(2026, 3, 29, 3, 30, 0, 0, berlin)},
+		{"spring forward, the day after", "30 2 * * *", time.Date(2026, 3, 29, 3, 30, 0, 0, berlin), time.Date(2026, 3, 30, 2, 30, 0, 0, berlin)},
+		{"spring forward, other times", "0 * * * *", time.Date(2026, 3, 29, 1, 30, 0, 0, berlin), time.Date(2026, 3, 29, 3, 0, 0, 0, berlin)},
+		{"spring forward, new york", "30 2 * * *", time.Date(2026, 3, 7, 2, 30, 0, 0, newYork), time.Date(2026, 3, 8, 3, 30, 0, 0, newYork)},
+		// Clocks go back at 3:00, so 2:00-3:00 happens twice and only runs the first time
+		{"fall back, first time", "30 2 * * *", time.Date(2026, 10, 24, 2, 30, 0, 0, berlin),
+			time.Date(2026, 10, 25, 0, 30, 0, 0, utc)},
+		{"fall back, not again", "*/30 * * * *", time.Date(2026, 10, 25, 0, 30, 0, 0, utc).In(berlin),
+			time.Date(2026, 10, 25, 2, 0, 0, 0, utc)},
+		{"fall back, the day after", "30 2 * * *", time.Date(2026, 10, 25, 0, 30, 0, 0, utc).In(berlin),
+			time.Date(2026, 10, 26, 2, 30, 0, 0, berlin)},
+		{"fall back, new york", "30 1 * * *", time.Date(2026, 10, 31, 1, 30, 0, 0, newYork),
+			time.Date(2026, 11, 1, 5, 30, 0, 0, utc)},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got := c.next(tt.from)
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: %q after %s: got %s, want %s", tt.name, tt.expr, tt.from, got, tt.want.In(tt.from.Location()))
+		}
+		if got.Location() != tt.from.Location() {
+			t.Errorf("%s: got a time in %s, want %s", tt.name, got.Location(), tt.from.Location())
+		}
+	}
+}
+
+func TestCronMaxGap(t *testing.T) {
+	from := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Duration
+	}{
+		{"*/15 * * * *", 15 * time.Minute},
+		{"*/10 8-23 * * *", 8*time.Hour + 10*time.Minute},
+		{"0 9 * * mon-fri", 72 * time.Hour},
+		// Runs less than once a week
+		{"0 0 1 * *", 30 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.maxGap(from); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	day := func(month time.Month, d, hour, minute int) time.Time {
+		return time.Date(2026, month, d, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		period string
+		at     time.Time
+		quiet  bool
+		until  time.Time
+	}{
+		// Across midnight
+		{"22:00-07:00", day(10, 18, 21, 59), false, time.Time{}},
+		{"22:00-07:00", day(10, 18, 22, 0), true, day(10, 19, 7, 0)},
+		{"22:00-07:00", day(10, 18, 23, 30), true, day(10, 19, 7, 0)},
+		{"22:00-07:00", day(10, 19, 0, 0), true, day(10, 19, 7, 0)},
+		{"22:00-07:00", day(10, 19, 6, 59), true, day(10, 19, 7, 0)},
+		{"22:00-07:00", day(10, 19, 7, 0), false, time.Time{}},
+		{"22:00-07:00", day(10, 31, 23, 0), true, day(11, 1, 7, 0)},
+		{"22:00-07:00", day(12, 31, 23, 0), true, time.Date(2027, 1, 1, 7, 0, 0, 0, time.UTC)},
+		// Within a day
+		{"09:00-17:30", day(10, 18, 8, 59), false, time.Time{}},
+		{"09:00-17:30", day(10, 18, 12, 0), true, day(10, 18, 17, 30)},
+		{"09:00-17:30", day(10, 18, 17, 30), false, time.Time{}},
+	}
+	for _, tt := range tests {
+		q, err := parseQuietHours(tt.period)
+		if err != nil {
+			t.Fatal(err)
+		}
+		until, quiet := q.until(tt.at)
+		if quiet != tt.quiet || !until.Equal(tt.until) {
+			t.Errorf("%s at %s: got %v until %s, want %v until %s", tt.period, tt.at, quiet, until, tt.quiet, tt.until)
+		}
+	}
+
+	for _, period := range []string{"22-07", "22:00", "22:00-22:00", "25:00-07:00", "22:00-07:00-08:00"} {
+		if _, err := parseQuietHours(period); err == nil {
+			t.Errorf("%q: expected an error", period)
+		}
+	}
+}
+
+func TestPlanAdaptiveStaysInBounds(t *testing.T) {
+	withOutbox(t)
+	s := newTestSearch("fg")
+	var conf Config
+	conf.Polling.Adaptive = true
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		min, max Minutes
+		jitter   time.Duration
+		prev     time.Duration
+		found    int
+	}{
+		{min: 5, max: 60, jitter: 10 * time.Minute, found: 1},
+		{min: 5, max: 60, jitter: 10 * time.Minute, prev: 50 * time.Minute},
+		{min: 5, max: 60, jitter: 10 * time.Minute, prev: time.Hour},
+		// More jitter than there is room for
+		{min: 5, max: 6, jitter: 10 * time.Minute, prev: 6 * time.Minute},
+	}
+	for _, tt := range tests {
+		conf.Polling.MinMinutes, conf.Polling.MaxMinutes, conf.Polling.Jitter = tt.min, tt.max, tt.jitter
+		prev := pollPlan{interval: tt.prev, key: s.scheduleKey(conf)}
+		for i := 0; i < 200; i++ {
+			p := s.plan(conf, now, prev, tt.found)
+			if wait := p.next.Sub(now); wait < tt.min.Duration() || wait > tt.max.Duration() {
+				t.Fatalf("%d-%d minutes with %s jitter after %s: waiting %s", tt.min, tt.max, tt.jitter, tt.prev, wait)
+			}
+		}
+	}
+}
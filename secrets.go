@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// redacted replaces secrets in logs, errors and reports
+const redacted = "[REDACTED]"
+
+// secretValues are every token, password and secret the bot has been configured with.
+// Values are never removed, so the ones from before a reload stay redacted as well.
+var secretsMu sync.RWMutex
+var secretValues = make(map[string]bool)
+
+// secretRef is a credential that can be set directly, or read from a file
+// (e.g. a Docker or Kubernetes secret). Credentials without a file setting are only redacted.
+type secretRef struct {
+	name  string
+	value *string
+	file  string
+}
+
+// secretRefs returns every credential in the configuration
+func secretRefs(cfg *Config) []secretRef {
+	refs := []secretRef{
+		{"discord.token", &cfg.Discord.Token, cfg.Discord.TokenFile},
+		{"slack.webhook_url", &cfg.Slack.WebhookURL, cfg.Slack.WebhookURLFile},
+		{"matrix.token", &cfg.Matrix.Token, cfg.Matrix.TokenFile},
+		{"email.password", &cfg.Email.Password, cfg.Email.PasswordFile},
+		{"webhook.secret", &cfg.Webhook.Secret, cfg.Webhook.SecretFile},
+		{"mastodon.token", &cfg.Mastodon.Token, cfg.Mastodon.TokenFile},
+		{"telegram.token", &cfg.Telegram.Token, cfg.Telegram.TokenFile},
+	}
+	// Webhook URLs often have a token in them, so they are redacted too
+	for i := range cfg.Webhook.URLs {
+		refs = append(refs, secretRef{"webhook.urls[" + strconv.Itoa(i) + "]", &cfg.Webhook.URLs[i], ""})
+	}
+	for i := range cfg.Searches {
+		sc := &cfg.Searches[i]
+		name := sc.Name
+		if name == "" {
+			name = "search-" + strconv.Itoa(i+1)
+		}
+		prefix := "search " + strconv.Quote(name) + " "
+		refs = append(refs, secretRef{prefix + "slack webhook_url", &sc.Slack.WebhookURL, sc.Slack.WebhookURLFile})
+		for j := range sc.Webhooks {
+			wh := &sc.Webhooks[j]
+			refs = append(refs, secretRef{prefix + "webhook secret", &wh.Secret, wh.SecretFile})
+			refs = append(refs, secretRef{prefix + "webhook url", &wh.URL, ""})
+		}
+	}
+	return refs
+}
+
+// readSecretFiles fills in the credentials that are set with a *_file setting,
+// and registers every credential to be redacted. It returns every problem it finds.
+func readSecretFiles(cfg *Config) []error {
+	var problems []error
+	for _, ref := range secretRefs(cfg) {
+		if ref.file == "" {
+			continue
+		}
+		if *ref.value != "" {
+			problems = append(problems, fmt.Errorf("%s is set both directly and from a file, only set one of them", ref.name))
+			continue
+		}
+		data, err := ioutil.ReadFile(ref.file)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("could not read %s from a file: %v", ref.name, err))
+			continue
+		}
+		// Files usually end with a newline, which is never part of the secret
+		*ref.value = strings.TrimSpace(string(data))
+		if *ref.value == "" {
+			problems = append(problems, fmt.Errorf("the file %s is read from is empty: %s", ref.name, ref.file))
+		}
+	}
+	registerSecrets(cfg)
+	return problems
+}
+
+// registerSecrets adds the credentials of a configuration to the values that are redacted
+func registerSecrets(cfg *Config) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, ref := range secretRefs(cfg) {
+		// The placeholder is not a secret, and the problems about it should say so
+		if *ref.value != "" && !strings.Contains(*ref.value, placeholder) {
+			secretValues[*ref.value] = true
+		}
+	}
+}
+
+// redact replaces every known secret in s
+func redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	if len(secretValues) == 0 {
+		return s
+	}
+	// Longest first, so a secret that contains another one is replaced as a whole
+	values := make([]string, 0, len(secretValues))
+	for v := range secretValues {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		s = strings.Replace(s, v, redacted, -1)
+	}
+	return s
+}
+
+// redactError returns the text of err with every known secret replaced
+func redactError(err error) string {
+	return redact(err.Error())
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// startServer starts the optional embedded HTTP server in the background
+// and registers the handlers for every enabled feature.
+func startServer(cfg Config) *http.Server {
+	mux := http.NewServeMux()
+	if feeds != nil {
+		mux.Handle("/feeds/", feeds)
+	}
+	if cfg.Metrics.Enabled {
+		mux.HandleFunc("/metrics", serveMetrics)
+	}
+	if cfg.Health.Enabled {
+		mux.HandleFunc("/healthz", serveHealthz)
+		mux.HandleFunc("/readyz", serveReadyz)
+	}
+
+	srv := &http.Server{
+		Addr:         cfg.Server.Listen,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTP server stopped", "listen", cfg.Server.Listen, "error", err)
+		}
+	}()
+	logger.Info("HTTP server listening", "listen", cfg.Server.Listen)
+	return srv
+}
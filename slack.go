@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// slackNotifier posts products to a Slack incoming webhook using Block Kit.
+type slackNotifier struct {
+	webhookURL string
+}
+
+// slackText is a Block Kit text object
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackImage is a Block Kit image element, used as a section accessory
+type slackImage struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+// slackBlock is a Block Kit section or divider
+type slackBlock struct {
+	Type      string      `json:"type"`
+	Text      *slackText  `json:"text,omitempty"`
+	Fields    []slackText `json:"fields,omitempty"`
+	Accessory *slackImage `json:"accessory,omitempty"`
+}
+
+// slackPayload is the body of an incoming webhook request
+type slackPayload struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// Name returns the notifier description
+func (n *slackNotifier) Name() string {
+	return "Slack webhook"
+}
+
+// Notify renders the product as Block Kit sections and posts it to the webhook
+func (n *slackNotifier) Notify(ctx context.Context, p Product) error {
+	body, err := json.Marshal(slackMessage(p))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Slack answers with a plain text "ok" or an error code like "invalid_blocks".
+	reply, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack webhook returned %s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
+
+// slackMessage builds the Block Kit message for a product:
+// the linked title and description, the price details and the cover image.
+func slackMessage(p Product) slackPayload {
+	title := &slackText{
+		Type: "mrkdwn",
+		Text: "*<" + p.Link + "|" + slackEscape(p.Title) + ">*",
+	}
+	if p.Description != "" {
+		// Section text is limited to 3000 characters
+		title.Text = title.Text + "\n" + truncate(slackEscape(p.Description), 2500)
+	}
+	summary := slackBlock{Type: "section", Text: title}
+	if p.Image != "" {
+		summary.Accessory = &slackImage{Type: "image", ImageURL: p.Image, AltText: p.Title}
+	}
+
+	details := slackBlock{
+		Type: "section",
+		Fields: []slackText{
+			{Type: "mrkdwn", Text: "*Price*\n" + slackEscape(p.PriceText())},
+			{Type: "mrkdwn", Text: "*Date Added*\n" + p.DateAdded},
+		},
+	}
+
+	return slackPayload{
+		// Text is the fallback used in notifications
+		Text:   p.Title + " - " + p.PriceText(),
+		Blocks: []slackBlock{summary, details, {Type: "divider"}},
+	}
+}
+
+// slackEscape escapes the three characters that Slack treats as control characters in mrkdwn.
+func slackEscape(s string) string {
+	s = strings.Replace(s, "&", "&amp;", -1)
+	s = strings.Replace(s, "<", "&lt;", -1)
+	return strings.Replace(s, ">", "&gt;", -1)
+}
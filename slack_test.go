@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSlackNotify(t *testing.T) {
+	var got slackPayload
+	var contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("not a JSON body: %v: %s", err, body)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	p := Product{
+		ID:          "123",
+		Title:       "Tomb of <Horrors> & More",
+		Description: strings.Repeat("é", 3000),
+		DateAdded:   "2026-10-18",
+		Price:       "$4.99 $2.99",
+		Link:        "https://example.com/product/123",
+		Image:       "https://example.com/cover.jpg",
+	}
+	n := &slackNotifier{webhookURL: srv.URL}
+	if err := n.Notify(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "application/json" {
+		t.Errorf("content type %q", contentType)
+	}
+	if got.Text != p.Title+" - $2.99 (normally $4.99)" {
+		t.Errorf("fallback text %q", got.Text)
+	}
+	if len(got.Blocks) != 3 || got.Blocks[2].Type != "divider" {
+		t.Fatalf("unexpected blocks: %+v", got.Blocks)
+	}
+
+	summary := got.Blocks[0]
+	title := "*<https://example.com/product/123|Tomb of &lt;Horrors&gt; &amp; More>*\n"
+	if !strings.HasPrefix(summary.Text.Text, title) {
+		t.Errorf("title %q", summary.Text.Text)
+	}
+	description := strings.TrimPrefix(summary.Text.Text, title)
+	if utf8.RuneCountInString(description) != 2500 || !strings.HasSuffix(description, "…") {
+		t.Errorf("description is %d characters, want it cut to 2500", utf8.RuneCountInString(description))
+	}
+	if summary.Accessory == nil || summary.Accessory.ImageURL != p.Image || summary.Accessory.AltText != p.Title {
+		t.Errorf("unexpected accessory: %+v", summary.Accessory)
+	}
+
+	fields := got.Blocks[1].Fields
+	if len(fields) != 2 || fields[0].Text != "*Price*\n$2.99 (normally $4.99)" || fields[1].Text != "*Date Added*\n2026-10-18" {
+		t.Errorf("unexpected fields: %+v", fields)
+	}
+}
+
+func TestSlackNotifyError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid_blocks\n"))
+	}))
+	defer srv.Close()
+
+	n := &slackNotifier{webhookURL: srv.URL}
+	err := n.Notify(context.Background(), Product{Title: "Title", Link: "https://example.com"})
+	if err == nil || !strings.HasSuffix(err.Error(), ": invalid_blocks") {
+		t.Fatalf("expected Slack's error code, got %v", err)
+	}
+}
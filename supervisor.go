@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spkane/discord_bot_dmsguild_search/dmsguild"
+)
+
+// fetchBackoff is the wait before the second attempt at fetching a search, it doubles after that
+var fetchBackoff = 2 * time.Second
+
+const (
+	// fetchAttempts is how many times a search is fetched before the poll counts as failed
+	fetchAttempts = 3
+	// breakerThreshold is how many polls of a search in a row have to fail before it is paused
+	breakerThreshold = 3
+	// breakerMaxCooldown is the longest a search is paused for
+	breakerMaxCooldown = time.Hour
+)
+
+// breaker is the circuit breaker of a search. Once breakerThreshold polls in a row have failed,
+// the search is paused for an interval, and the pause doubles every time the next poll fails too.
+// A successful poll closes it again.
+type breaker struct {
+	failures    int
+	cooldown    time.Duration
+	openUntil   time.Time
+	lastError   error
+	lastSuccess time.Time
+}
+
+// breakers are kept by search name, so they survive reloads
+var breakersMu sync.Mutex
+var breakers = make(map[string]*breaker)
+
+// breakerFor returns the circuit breaker of a search
+func breakerFor(name string) *breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[name]
+	if !ok {
+		b = &breaker{lastSuccess: time.Now()}
+		breakers[name] = b
+	}
+	return b
+}
+
+// allow reports whether the search may be polled now
+func (b *breaker) allow(now time.Time) bool {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	return !now.Before(b.openUntil)
+}
+
+// success records a successful poll, closing the breaker
+func (b *breaker) success(name string) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	if b.failures >= breakerThreshold {
+		logger.Info("search is working again", "search", name, "failures", b.failures)
+	}
+	b.failures = 0
+	b.cooldown = 0
+	b.openUntil = time.Time{}
+	b.lastError = nil
+	b.lastSuccess = time.Now()
+	breakerOpen.Set(0, name)
+}
+
+// failure records a failed poll, and opens the breaker once there have been too many in a row.
+// interval is how often the search is normally polled, which is the first pause.
+func (b *breaker) failure(name string, err error, interval time.Duration) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b.failures++
+	b.lastError = err
+	if b.failures < breakerThreshold {
+		return
+	}
+	if b.cooldown == 0 {
+		b.cooldown = interval
+	} else {
+		b.cooldown = b.cooldown * 2
+	}
+	if b.cooldown > breakerMaxCooldown {
+		b.cooldown = breakerMaxCooldown
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+	breakerOpen.Set(1, name)
+	logger.Warn("pausing search after repeated failures", "search", name, "failures", b.failures,
+		"paused_for", b.cooldown, "since_last_success", time.Since(b.lastSuccess).Round(time.Second), "error", err)
+}
+
+// fetchPage runs the search, retrying with backoff when it fails, until ctx is done.
+// It returns how many attempts it took. Failed attempts are only counted and logged at debug level,
+// the caller logs the search once if every attempt failed.
+func fetchPage(ctx context.Context, s *search) (*dmsguild.Page, int, error) {
+	wait := fetchBackoff
+	var err error
+	for attempt := 1; attempt <= fetchAttempts; attempt++ {
+		var page *dmsguild.Page
+		page, err = searchPage(ctx, s)
+		if err == nil {
+			return page, attempt, nil
+		}
+		fetchErrors.Inc(s.Name)
+		if ctx.Err() != nil {
+			return nil, attempt, err
+		}
+		if attempt < fetchAttempts {
+			logger.Debug("could not fetch search results, retrying", "search", s.Name, "attempt", attempt, "retry_in", wait, "error", err)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, attempt, ctx.Err()
+			}
+			wait = wait * 2
+		}
+	}
+	return nil, fetchAttempts, err
+}
+
+// searchesFailed is the error of a check in which some of the searches failed.
+// Each of them has already been logged and counted, so it isn't a job error.
+type searchesFailed struct {
+	failed []string
+	total  int
+}
+
+func (e *searchesFailed) Error() string {
+	return fmt.Sprintf("could not check %d of %d searches: %s", len(e.failed), e.total, strings.Join(e.failed, ", "))
+}
+
+// supervise runs a job, logging and counting its error, and recovering from a panic
+// so the scheduler keeps running. Searches that failed are not counted again as a job error.
+func supervise(job string, run func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+		if _, ok := err.(*searchesFailed); err != nil && !ok {
+			jobErrors.Inc(job)
+			logger.Error("scheduled job failed", "job", job, "error", err)
+		}
+	}()
+	return run()
+}
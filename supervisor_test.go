@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spkane/discord_bot_dmsguild_search/dmsguild"
+)
+
+// withLog sends the log to a buffer for the duration of a test
+func withLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var out bytes.Buffer
+	saved := logger
+	logger = &Logger{out: &out, level: levelInfo}
+	t.Cleanup(func() { logger = saved })
+	return &out
+}
+
+// metricValue returns the value of a metric for the label values
+func metricValue(m *metricVec, labelValues ...string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[strings.Join(labelValues, "\xff")]
+}
+
+func TestFailedSearchIsLoggedOnce(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	savedClient, savedBackoff := client, fetchBackoff
+	client = dmsguild.NewClient(dmsguild.WithBaseURL(srv.URL))
+	fetchBackoff = time.Millisecond
+	t.Cleanup(func() { client, fetchBackoff = savedClient, savedBackoff })
+
+	s := newTestSearch("logged-once")
+	s.Keywords = "tomb"
+	withSearches(t, s)
+	withOutbox(t)
+	out := withLog(t)
+	jobs := metricValue(jobErrors, "check")
+	fetches := metricValue(fetchErrors, s.Name)
+
+	err := supervise("check", func() error { return updateMessage(context.Background(), []*search{s}) })
+	if _, ok := err.(*searchesFailed); !ok {
+		t.Fatalf("expected the search to fail, got %v", err)
+	}
+	if requests != fetchAttempts {
+		t.Errorf("fetched %d times, want %d", requests, fetchAttempts)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "could not check search") ||
+		!strings.Contains(lines[0], "search=logged-once") || !strings.Contains(lines[0], "attempts=3") {
+		t.Errorf("expected a single line with the search and attempts, got\n%s", out.String())
+	}
+	if got := metricValue(jobErrors, "check") - jobs; got != 0 {
+		t.Errorf("counted %v job errors for a failed search", got)
+	}
+	if got := metricValue(fetchErrors, s.Name) - fetches; got != fetchAttempts {
+		t.Errorf("counted %v failed fetches, want %d", got, fetchAttempts)
+	}
+}
+
+func TestSuperviseCountsJobErrors(t *testing.T) {
+	out := withLog(t)
+	before := metricValue(jobErrors, "test job")
+
+	if err := supervise("test job", func() error { return errors.New("broken") }); err == nil {
+		t.Error("expected the error")
+	}
+	if err := supervise("test job", func() error { panic("oops") }); err == nil || !strings.Contains(err.Error(), "panic: oops") {
+		t.Errorf("expected the panic as an error, got %v", err)
+	}
+	if got := metricValue(jobErrors, "test job") - before; got != 2 {
+		t.Errorf("counted %v job errors, want 2", got)
+	}
+	if got := strings.Count(out.String(), "scheduled job failed"); got != 2 {
+		t.Errorf("logged %d job failures, want 2", got)
+	}
+}
+
+func TestQueueResultsUnlocksAfterPanic(t *testing.T) {
+	withLog(t)
+	saved := box
+	// Without an outbox, checking whether the product is new panics
+	box = nil
+	t.Cleanup(func() { box = saved })
+	s := newTestSearch("panics")
+	page := &dmsguild.Page{Rows: 1, Products: []dmsguild.Product{{ID: "1", Title: "Sharn", DateAdded: memoryDate}}}
+
+	err := supervise("check", func() error {
+		queueResults(context.Background(), []*search{s}, []fetchResult{{page: page, start: time.Now()}})
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "panic") {
+		t.Fatalf("expected the panic, got %v", err)
+	}
+	if !queueMu.TryLock() {
+		t.Fatal("queueMu is still locked after the panic")
+	}
+	queueMu.Unlock()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Telegram limits, counted on the text after the HTML has been parsed
+const (
+	telegramCaptionLimit = 1024
+	telegramMessageLimit = 4096
+)
+
+// telegramNotifier posts products to a Telegram chat or channel through the Bot API.
+type telegramNotifier struct {
+	apiURL string
+	token  string
+	chatID string
+}
+
+// telegramButton is an inline keyboard button that opens a URL
+type telegramButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+// telegramMarkup is an inline keyboard, one row of buttons per slice
+type telegramMarkup struct {
+	InlineKeyboard [][]telegramButton `json:"inline_keyboard"`
+}
+
+// telegramRequest is the body of both sendPhoto and sendMessage
+type telegramRequest struct {
+	ChatID                string         `json:"chat_id"`
+	Photo                 string         `json:"photo,omitempty"`
+	Caption               string         `json:"caption,omitempty"`
+	Text                  string         `json:"text,omitempty"`
+	ParseMode             string         `json:"parse_mode"`
+	DisableWebPagePreview bool           `json:"disable_web_page_preview,omitempty"`
+	ReplyMarkup           telegramMarkup `json:"reply_markup"`
+}
+
+// telegramResponse is the envelope of every Bot API response
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+}
+
+// Name returns the notifier description
+func (n *telegramNotifier) Name() string {
+	return "Telegram chat " + n.chatID
+}
+
+// Notify posts the cover photo with the product as its caption,
+// or a plain message if there is no cover or Telegram can't fetch it.
+func (n *telegramNotifier) Notify(ctx context.Context, p Product) error {
+	markup := telegramMarkup{InlineKeyboard: [][]telegramButton{{{Text: "View on DMs Guild", URL: p.Link}}}}
+
+	if p.Image != "" {
+		status, err := n.call(ctx, "sendPhoto", telegramRequest{
+			ChatID:      n.chatID,
+			Photo:       p.Image,
+			Caption:     telegramHTML(p, telegramCaptionLimit),
+			ParseMode:   "HTML",
+			ReplyMarkup: markup,
+		})
+		// A 400 usually means Telegram could not download the image, so fall back to text.
+		if err == nil || status != http.StatusBadRequest {
+			return err
+		}
+	}
+
+	_, err := n.call(ctx, "sendMessage", telegramRequest{
+		ChatID:                n.chatID,
+		Text:                  telegramHTML(p, telegramMessageLimit),
+		ParseMode:             "HTML",
+		DisableWebPagePreview: true,
+		ReplyMarkup:           markup,
+	})
+	return err
+}
+
+// call invokes a Bot API method, returning the HTTP status code along with any error
+func (n *telegramNotifier) call(ctx context.Context, method string, r telegramRequest) (int, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+
+	endpoint := strings.TrimRight(n.apiURL, "/") + "/bot" + n.token + "/" + method
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("telegram %s request failed: %s", method, n.redactError(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// The error includes the URL, which includes the bot token.
+		return 0, fmt.Errorf("telegram %s request failed: %s", method, n.redactError(err))
+	}
+	defer resp.Body.Close()
+
+	reply, _ := ioutil.ReadAll(resp.Body)
+	var result telegramResponse
+	if err = json.Unmarshal(reply, &result); err != nil {
+		return resp.StatusCode, fmt.Errorf("telegram %s returned %s: %s", method, resp.Status, strings.TrimSpace(string(reply)))
+	}
+	if !result.OK {
+		return resp.StatusCode, fmt.Errorf("telegram %s returned %d: %s", method, result.ErrorCode, result.Description)
+	}
+	return resp.StatusCode, nil
+}
+
+// redactError returns the text of err with the bot token and every other known secret replaced.
+// The token is replaced here as well, in case it was never registered as a secret.
+func (n *telegramNotifier) redactError(err error) string {
+	return strings.Replace(redactError(err), n.token, redacted, -1)
+}
+
+// telegramHTML renders the product with Telegram's HTML subset, shortening the
+// description so the visible text stays within limit characters.
+func telegramHTML(p Product, limit int) string {
+	head := "<b>" + html.EscapeString(p.Title) + "</b>\n"
+	head = head + "<b>Price</b>: " + html.EscapeString(p.PriceText()) + "\n"
+	head = head + "<b>Date Added</b>: " + html.EscapeString(p.DateAdded)
+	visible := utf8.RuneCountInString(p.Title) + utf8.RuneCountInString(p.PriceText()) + utf8.RuneCountInString(p.DateAdded) + 21
+
+	if visible > limit {
+		return html.EscapeString(truncate(p.Title, limit))
+	}
+	room := limit - visible - 2
+	if p.Description != "" && room > 20 {
+		head = head + "\n\n" + html.EscapeString(truncate(p.Description, room))
+	}
+	return head
+}
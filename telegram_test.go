@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// telegramTag matches the HTML tags Telegram doesn't count towards its limits
+var telegramTag = regexp.MustCompile(`</?b>`)
+
+// telegramVisible counts the characters Telegram shows for an HTML text
+func telegramVisible(s string) int {
+	return utf8.RuneCountInString(html.UnescapeString(telegramTag.ReplaceAllString(s, "")))
+}
+
+// fakeTelegram is a Bot API server that answers sendPhoto with photoStatus and records every call
+func fakeTelegram(t *testing.T, photoStatus int) (*httptest.Server, map[string]telegramRequest) {
+	t.Helper()
+	calls := map[string]telegramRequest{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s: content type %q", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var req telegramRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("%s: not a JSON body: %v", r.URL.Path, err)
+		}
+		calls[r.URL.Path] = req
+		if strings.HasSuffix(r.URL.Path, "/sendPhoto") && photoStatus != http.StatusOK {
+			w.WriteHeader(photoStatus)
+			w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: wrong file identifier/HTTP URL specified"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+func TestTelegramNotify(t *testing.T) {
+	srv, calls := fakeTelegram(t, http.StatusOK)
+	p := Product{
+		Title:       "Dungeons & <Dragons>",
+		Description: strings.Repeat("&", 2000),
+		DateAdded:   "2026-10-18",
+		Price:       "$4.99 $2.99",
+		Link:        "https://example.com/product/1",
+		Image:       "https://example.com/cover.jpg",
+	}
+	n := &telegramNotifier{apiURL: srv.URL + "/", token: "123:abc", chatID: "@channel"}
+	if err := n.Notify(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(calls) != 1 {
+		t.Fatalf("expected only sendPhoto, got %v", calls)
+	}
+	req, ok := calls["/bot123:abc/sendPhoto"]
+	if !ok {
+		t.Fatalf("sendPhoto wasn't called: %v", calls)
+	}
+	if req.ChatID != "@channel" || req.Photo != p.Image || req.ParseMode != "HTML" {
+		t.Errorf("unexpected request: %+v", req)
+	}
+	if !strings.HasPrefix(req.Caption, "<b>Dungeons &amp; &lt;Dragons&gt;</b>\n<b>Price</b>: $2.99 (normally $4.99)\n<b>Date Added</b>: 2026-10-18\n\n&amp;&amp;") {
+		t.Errorf("caption %q", req.Caption)
+	}
+	// The escaped description is much longer, but only what is visible counts
+	if got := telegramVisible(req.Caption); got != telegramCaptionLimit {
+		t.Errorf("caption shows %d characters, want the description cut to fit %d", got, telegramCaptionLimit)
+	}
+	buttons := req.ReplyMarkup.InlineKeyboard
+	if len(buttons) != 1 || len(buttons[0]) != 1 || buttons[0][0].URL != p.Link {
+		t.Errorf("unexpected keyboard: %+v", buttons)
+	}
+}
+
+func TestTelegramNotifyFallsBackToMessage(t *testing.T) {
+	srv, calls := fakeTelegram(t, http.StatusBadRequest)
+	p := Product{Title: "Title", Description: strings.Repeat("d", 5000), Link: "https://example.com/product/1", Image: "https://example.com/missing.jpg"}
+	n := &telegramNotifier{apiURL: srv.URL, token: "123:abc", chatID: "42"}
+	if err := n.Notify(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	req, ok := calls["/bot123:abc/sendMessage"]
+	if !ok || len(calls) != 2 {
+		t.Fatalf("expected sendPhoto and then sendMessage, got %v", calls)
+	}
+	if req.Photo != "" || req.Caption != "" || !req.DisableWebPagePreview {
+		t.Errorf("unexpected request: %+v", req)
+	}
+	if got := telegramVisible(req.Text); got != telegramMessageLimit {
+		t.Errorf("message shows %d characters, want the description cut to fit %d", got, telegramMessageLimit)
+	}
+	if len(req.ReplyMarkup.InlineKeyboard) != 1 {
+		t.Errorf("the message has no keyboard: %+v", req.ReplyMarkup)
+	}
+}
+
+func TestTelegramErrorHidesToken(t *testing.T) {
+	srv, _ := fakeTelegram(t, http.StatusForbidden)
+	n := &telegramNotifier{apiURL: srv.URL, token: "123:abc", chatID: "42"}
+	err := n.Notify(context.Background(), Product{Title: "Title", Image: "https://example.com/cover.jpg"})
+	if err == nil || !strings.Contains(err.Error(), "wrong file identifier") {
+		t.Fatalf("expected Telegram's error, got %v", err)
+	}
+
+	// Errors from the HTTP client include the URL, and so the token
+	n = &telegramNotifier{apiURL: "http://127.0.0.1:0", token: "123:abc", chatID: "42"}
+	err = n.Notify(context.Background(), Product{Title: "Title"})
+	if err == nil || strings.Contains(err.Error(), "123:abc") || !strings.Contains(err.Error(), "/bot"+redacted+"/") {
+		t.Fatalf("expected an error without the token, got %v", err)
+	}
+}
+
+func TestTelegramHTMLLongTitle(t *testing.T) {
+	got := telegramHTML(Product{Title: strings.Repeat("<", 2000), Price: "$1.00"}, telegramCaptionLimit)
+	if telegramVisible(got) != telegramCaptionLimit {
+		t.Errorf("shows %d characters", telegramVisible(got))
+	}
+	if strings.Contains(got, "<") {
+		t.Errorf("title isn't escaped: %q", got)
+	}
+}
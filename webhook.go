@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// webhookVersion is the version of the JSON document we POST.
+// Bump it whenever a field is removed or changes meaning.
+const webhookVersion = 1
+
+// webhookDeliveryCounter keeps delivery IDs unique within a single run,
+// for products that are sent without going through the outbox.
+var webhookDeliveryCounter uint64
+
+// webhookNotifier POSTs a signed JSON document for every product to a URL.
+type webhookNotifier struct {
+	url    string
+	secret string
+	search string
+}
+
+// webhookProduct is the product as it appears in the webhook document
+type webhookProduct struct {
+	ID          string `json:"id,omitempty"`
+	Title       string `json:"title"`
+	DateAdded   string `json:"date_added"`
+	Description string `json:"description"`
+	Price       string `json:"price"`
+	PriceText   string `json:"price_text"`
+	Link        string `json:"link"`
+	Image       string `json:"image,omitempty"`
+}
+
+// webhookDocument is the versioned body of every webhook request
+type webhookDocument struct {
+	Version  int            `json:"version"`
+	Event    string         `json:"event"`
+	Delivery string         `json:"delivery"`
+	Search   string         `json:"search"`
+	SentAt   string         `json:"sent_at"`
+	Product  webhookProduct `json:"product"`
+}
+
+// Name returns the notifier description. It has the host and a hash of the URL rather than
+// the URL itself, which often has a token in it and ends up in logs, alerts and the state file.
+func (n *webhookNotifier) Name() string {
+	host := "webhook"
+	if u, err := url.Parse(n.url); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	sum := sha256.Sum256([]byte(n.url))
+	return "webhook " + host + " " + hex.EncodeToString(sum[:4])
+}
+
+// Notify POSTs the product once. Failed deliveries are retried by the outbox,
+// with the outbox item's ID as the delivery ID, so receivers can drop duplicates.
+func (n *webhookNotifier) Notify(ctx context.Context, p Product) error {
+	delivery, ok := deliveryID(ctx)
+	if !ok {
+		delivery = strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(atomic.AddUint64(&webhookDeliveryCounter, 1), 36)
+	}
+	body, err := json.Marshal(webhookDocument{
+		Version:  webhookVersion,
+		Event:    "product.matched",
+		Delivery: delivery,
+		Search:   n.search,
+		SentAt:   time.Now().UTC().Format(time.RFC3339),
+		Product: webhookProduct{
+			ID:          p.ID,
+			Title:       p.Title,
+			DateAdded:   p.DateAdded,
+			Description: p.Description,
+			Price:       p.Price,
+			PriceText:   p.PriceText(),
+			Link:        p.Link,
+			Image:       p.Image,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "discord_bot_dmsguild_search")
+	req.Header.Set("X-DMSGuild-Delivery", delivery)
+	if n.secret != "" {
+		req.Header.Set("X-DMSGuild-Signature", "sha256="+webhookSignature(n.secret, body))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	reply, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
+
+// webhookSignature returns the hex encoded HMAC-SHA256 of the body.
+// Receivers should compute the same over the raw request body and compare it
+// to the X-DMSGuild-Signature header with a constant time comparison.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
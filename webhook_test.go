@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	var requests []*http.Request
+	var bodies [][]byte
+	status := http.StatusInternalServerError
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	n := &webhookNotifier{url: srv.URL + "/hook", secret: "s3cret", search: "fg"}
+	p := Product{ID: "123", Title: "A <Title>", DateAdded: "2026-10-18", Price: "$4.99 $2.99", Link: "https://example.com/product/123"}
+	ctx := withDelivery(context.Background(), "item-1")
+
+	// A failure is returned straight away, the outbox does the retrying
+	if err := n.Notify(ctx, p); err == nil {
+		t.Fatal("expected an error for a 500")
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected a single attempt, got %d", len(requests))
+	}
+
+	status = http.StatusNoContent
+	if err := n.Notify(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range requests {
+		if got := r.Header.Get("X-DMSGuild-Delivery"); got != "item-1" {
+			t.Errorf("request %d: delivery %q, want the outbox item ID", i, got)
+		}
+		if got, want := r.Header.Get("X-DMSGuild-Signature"), "sha256="+webhookSignature("s3cret", bodies[i]); got != want {
+			t.Errorf("request %d: signature %q, want %q", i, got, want)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("request %d: content type %q", i, got)
+		}
+	}
+
+	var doc webhookDocument
+	if err := json.Unmarshal(bodies[1], &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != webhookVersion || doc.Event != "product.matched" || doc.Delivery != "item-1" || doc.Search != "fg" {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+	if doc.Product.ID != "123" || doc.Product.Title != p.Title || doc.Product.PriceText != "$2.99 (normally $4.99)" {
+		t.Errorf("unexpected product: %+v", doc.Product)
+	}
+}
+
+func TestWebhookName(t *testing.T) {
+	n := &webhookNotifier{url: "https://hooks.example.com/services/T0KEN"}
+	other := &webhookNotifier{url: "https://hooks.example.com/services/OTHER"}
+	if got := n.Name(); got != "webhook hooks.example.com 71f0287b" {
+		t.Errorf("got %q", got)
+	}
+	if n.Name() == other.Name() {
+		t.Errorf("two webhooks on the same host have the same name %q", n.Name())
+	}
+}